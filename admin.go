@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// adminSessionCookie holds the session of a signed-in admin
+	adminSessionCookie = "jtv_admin_session"
+	// DefaultAdminSessionTTL is how long an admin stays signed in
+	DefaultAdminSessionTTL = 7 * 24 * time.Hour
+	// loginFailureDelay slows down guessing the admin password
+	loginFailureDelay = time.Second
+	// csrfField is the form field carrying the session's CSRF token
+	csrfField = "csrf"
+)
+
+// AdminAuth guards the HTTP interface. Admins sign in with the admin password
+// and get a session cookie, or send an API token from the api_tokens table.
+// Sessions are kept in memory, so a restart signs everyone out.
+type AdminAuth struct {
+	passwordHash [sha256.Size]byte
+	hasPassword  bool
+	sessionTTL   time.Duration
+	tokens       *APITokenStore
+
+	mu       sync.Mutex
+	sessions map[string]adminSession // by session id
+}
+
+// adminSession is a signed-in admin
+type adminSession struct {
+	expires time.Time
+	// csrf must be sent with every change made with the session, so pages of
+	// other sites can't make them with the admin's cookie
+	csrf string
+}
+
+// NewAdminAuth creates the admin authentication. An empty password disables
+// password sign-in, leaving only API tokens.
+func NewAdminAuth(password string, sessionTTL time.Duration, tokens *APITokenStore) *AdminAuth {
+	return &AdminAuth{
+		passwordHash: sha256.Sum256([]byte(password)),
+		hasPassword:  password != "",
+		sessionTTL:   sessionTTL,
+		tokens:       tokens,
+		sessions:     make(map[string]adminSession),
+	}
+}
+
+// PasswordEnabled reports whether admins can sign in with a password
+func (a *AdminAuth) PasswordEnabled() bool {
+	return a.hasPassword
+}
+
+// checkPassword compares a password with the admin password in constant time
+func (a *AdminAuth) checkPassword(password string) bool {
+	if !a.hasPassword {
+		return false
+	}
+	hash := sha256.Sum256([]byte(password))
+	return subtle.ConstantTimeCompare(hash[:], a.passwordHash[:]) == 1
+}
+
+// randomToken returns 32 random bytes, base64url encoded
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// newSession starts a session and returns its id
+func (a *AdminAuth) newSession() (string, error) {
+	id, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	csrf, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sessions[id] = adminSession{expires: time.Now().Add(a.sessionTTL), csrf: csrf}
+
+	// Drop expired sessions
+	for k, session := range a.sessions {
+		if time.Now().After(session.expires) {
+			delete(a.sessions, k)
+		}
+	}
+	return id, nil
+}
+
+// session returns a session that exists and hasn't expired
+func (a *AdminAuth) session(id string) (adminSession, bool) {
+	if id == "" {
+		return adminSession{}, false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	session, ok := a.sessions[id]
+	if !ok {
+		return adminSession{}, false
+	}
+	if time.Now().After(session.expires) {
+		delete(a.sessions, id)
+		return adminSession{}, false
+	}
+	return session, true
+}
+
+// validSession reports whether a session exists and hasn't expired
+func (a *AdminAuth) validSession(id string) bool {
+	_, ok := a.session(id)
+	return ok
+}
+
+// CSRFToken returns the CSRF token forms of a session must send, or ""
+func (a *AdminAuth) CSRFToken(id string) string {
+	session, _ := a.session(id)
+	return session.csrf
+}
+
+// checkCSRF compares the CSRF token of a request with its session's in constant time
+func (a *AdminAuth) checkCSRF(id string, r *http.Request) bool {
+	session, ok := a.session(id)
+	token := r.PostFormValue(csrfField)
+	return ok && token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(session.csrf)) == 1
+}
+
+// endSession signs a session out
+func (a *AdminAuth) endSession(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.sessions, id)
+}
+
+// adminSession returns the id of the request's valid admin session, or ""
+func (s *Server) adminSession(r *http.Request) string {
+	if s.admin == nil {
+		return ""
+	}
+	cookie, err := r.Cookie(adminSessionCookie)
+	if err != nil || !s.admin.validSession(cookie.Value) {
+		return ""
+	}
+	return cookie.Value
+}
+
+// requestToken returns the API token of a request, sent as a bearer token.
+// With queryToken, the token query parameter of a GET request is accepted too,
+// for clients that can't set headers such as EventSource and OBS browser sources.
+func requestToken(r *http.Request, queryToken bool) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	if queryToken && r.Method == http.MethodGet {
+		return r.URL.Query().Get("token")
+	}
+	return ""
+}
+
+// RequireAdmin wraps a handler so only signed-in admins and API token holders
+// reach it. Browsers are sent to the sign-in page; other clients get 401.
+func (s *Server) RequireAdmin(h http.HandlerFunc) http.HandlerFunc {
+	return s.requireAdmin(h, false)
+}
+
+// RequireAdminFeed is RequireAdmin for the read-only feeds overlays load, which
+// also accept the API token as the token query parameter. Elsewhere it isn't
+// accepted, so tokens don't end up in logs and Referer headers of admin actions.
+func (s *Server) RequireAdminFeed(h http.HandlerFunc) http.HandlerFunc {
+	return s.requireAdmin(h, true)
+}
+
+// requireAdmin implements RequireAdmin and RequireAdminFeed
+func (s *Server) requireAdmin(h http.HandlerFunc, queryToken bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.admin == nil {
+			http.Error(w, "Admin authentication is not configured", http.StatusServiceUnavailable)
+			return
+		}
+
+		if id := s.adminSession(r); id != "" {
+			// The session cookie is sent with cross-site requests too, so
+			// changes must carry the session's CSRF token from a page of this server
+			if r.Method != http.MethodGet && r.Method != http.MethodHead && !s.admin.checkCSRF(id, r) {
+				http.Error(w, "Invalid or missing CSRF token", http.StatusForbidden)
+				return
+			}
+			h(w, r)
+			return
+		}
+
+		if token := requestToken(r, queryToken); token != "" && s.admin.tokens != nil {
+			_, err := s.admin.tokens.Authenticate(token)
+			if err == nil {
+				h(w, r)
+				return
+			}
+			if !errors.Is(err, errTokenNotFound) {
+				log.Printf("⚠️  Failed to check api token: %v", err)
+				http.Error(w, "Failed to check token", http.StatusInternalServerError)
+				return
+			}
+			log.Printf("⚠️  Rejected invalid api token from %s for %s", r.RemoteAddr, r.URL.Path)
+		}
+
+		if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+			http.Redirect(w, r, "/admin/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="joysticktv-receipt-bot"`)
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+	}
+}
+
+// safeRedirect returns next if it is a path on this server, or "/"
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// loginPage is the data of the sign-in page
+type loginPage struct {
+	Next            string
+	Error           string
+	PasswordEnabled bool
+}
+
+// HandleAdminLogin shows the sign-in page and signs admins in with the admin password
+func (s *Server) HandleAdminLogin(w http.ResponseWriter, r *http.Request) {
+	if s.admin == nil {
+		http.Error(w, "Admin authentication is not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	page := loginPage{
+		Next:            safeRedirect(r.FormValue("next")),
+		PasswordEnabled: s.admin.PasswordEnabled(),
+	}
+
+	if r.Method == http.MethodPost {
+		if !s.admin.checkPassword(r.FormValue("password")) {
+			log.Printf("⚠️  Failed admin sign-in from %s", r.RemoteAddr)
+			time.Sleep(loginFailureDelay)
+			page.Error = "Wrong password"
+			w.WriteHeader(http.StatusUnauthorized)
+			s.renderLoginPage(w, page)
+			return
+		}
+
+		id, err := s.admin.newSession()
+		if err != nil {
+			log.Printf("❌ Failed to start admin session: %v", err)
+			http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     adminSessionCookie,
+			Value:    id,
+			Path:     "/",
+			MaxAge:   int(s.admin.sessionTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		log.Printf("✓ Admin signed in from %s", r.RemoteAddr)
+		http.Redirect(w, r, page.Next, http.StatusSeeOther)
+		return
+	}
+
+	if s.adminSession(r) != "" {
+		http.Redirect(w, r, page.Next, http.StatusSeeOther)
+		return
+	}
+	s.renderLoginPage(w, page)
+}
+
+// renderLoginPage writes the sign-in page
+func (s *Server) renderLoginPage(w http.ResponseWriter, page loginPage) {
+	var buf bytes.Buffer
+	if err := dashboardTemplates.ExecuteTemplate(&buf, "login.html", page); err != nil {
+		log.Printf("❌ Failed to render sign-in page: %v", err)
+		http.Error(w, "Failed to render sign-in page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(buf.Bytes())
+}
+
+// HandleAdminLogout ends the admin session. Like every change made with the
+// session, it needs the session's CSRF token.
+func (s *Server) HandleAdminLogout(w http.ResponseWriter, r *http.Request) {
+	if id := s.adminSession(r); id != "" {
+		if !s.admin.checkCSRF(id, r) {
+			http.Error(w, "Invalid or missing CSRF token", http.StatusForbidden)
+			return
+		}
+		s.admin.endSession(id)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     adminSessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+}
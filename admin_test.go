@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// newAdminServer returns a server with the admin password "secret", a session
+// and an API token
+func newAdminServer(t *testing.T) (s *Server, session, token string) {
+	t.Helper()
+
+	appDB, err := NewAppDatabase(t.TempDir() + "/app.db")
+	if err != nil {
+		t.Fatalf("NewAppDatabase: %v", err)
+	}
+	t.Cleanup(func() { appDB.Close() })
+
+	tokens := NewAPITokenStore(appDB.GetDB())
+	token, err = tokens.CreateToken("obs")
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+
+	s = NewServer(testClientID, testClientSecret, testRedirectURL, t.TempDir()+"/credentials.json")
+	s.admin = NewAdminAuth("secret", DefaultAdminSessionTTL, tokens)
+	session, err = s.admin.newSession()
+	if err != nil {
+		t.Fatalf("newSession: %v", err)
+	}
+	return s, session, token
+}
+
+// okHandler answers 200
+func okHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// serve runs one request through the handler and returns the response code
+func serve(h http.HandlerFunc, r *http.Request) int {
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w.Code
+}
+
+// withSession adds the admin session cookie to a request
+func withSession(r *http.Request, session string) *http.Request {
+	r.AddCookie(&http.Cookie{Name: adminSessionCookie, Value: session})
+	return r
+}
+
+// withBearer adds an API token to a request
+func withBearer(r *http.Request, token string) *http.Request {
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestRequireAdminRejects(t *testing.T) {
+	s, _, _ := newAdminServer(t)
+	expired, err := s.admin.newSession()
+	if err != nil {
+		t.Fatalf("newSession: %v", err)
+	}
+	s.admin.endSession(expired)
+
+	browser := httptest.NewRequest(http.MethodGet, "/", nil)
+	browser.Header.Set("Accept", "text/html")
+
+	for _, tc := range []struct {
+		name string
+		req  *http.Request
+		want int
+	}{
+		{"no credentials", httptest.NewRequest(http.MethodGet, "/api/events", nil), http.StatusUnauthorized},
+		{"unknown token", withBearer(httptest.NewRequest(http.MethodGet, "/api/events", nil), "guess"), http.StatusUnauthorized},
+		{"signed out session", withSession(httptest.NewRequest(http.MethodGet, "/api/events", nil), expired), http.StatusUnauthorized},
+		{"browser page view", browser, http.StatusSeeOther},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := serve(s.RequireAdmin(okHandler), tc.req); got != tc.want {
+				t.Errorf("status = %d, want %d", got, tc.want)
+			}
+		})
+	}
+
+	s.admin = nil
+	if got := serve(s.RequireAdmin(okHandler), httptest.NewRequest(http.MethodGet, "/", nil)); got != http.StatusServiceUnavailable {
+		t.Errorf("status without admin authentication = %d, want %d", got, http.StatusServiceUnavailable)
+	}
+}
+
+func TestRequireAdminCSRF(t *testing.T) {
+	s, session, token := newAdminServer(t)
+
+	post := func(form url.Values) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/reconnect", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+
+	if got := serve(s.RequireAdmin(okHandler), withSession(post(nil), session)); got != http.StatusForbidden {
+		t.Errorf("session POST without a CSRF token = %d, want %d", got, http.StatusForbidden)
+	}
+	other, err := s.admin.newSession()
+	if err != nil {
+		t.Fatalf("newSession: %v", err)
+	}
+	wrong := url.Values{csrfField: {s.admin.CSRFToken(other)}}
+	if got := serve(s.RequireAdmin(okHandler), withSession(post(wrong), session)); got != http.StatusForbidden {
+		t.Errorf("session POST with another session's CSRF token = %d, want %d", got, http.StatusForbidden)
+	}
+	valid := url.Values{csrfField: {s.admin.CSRFToken(session)}}
+	if got := serve(s.RequireAdmin(okHandler), withSession(post(valid), session)); got != http.StatusOK {
+		t.Errorf("session POST with its CSRF token = %d, want %d", got, http.StatusOK)
+	}
+	if got := serve(s.RequireAdmin(okHandler), withBearer(post(nil), token)); got != http.StatusOK {
+		t.Errorf("API token POST = %d, want %d", got, http.StatusOK)
+	}
+}
+
+func TestQueryTokenOnlyOnFeeds(t *testing.T) {
+	s, _, token := newAdminServer(t)
+	query := "?token=" + url.QueryEscape(token)
+
+	if got := serve(s.RequireAdminFeed(okHandler), httptest.NewRequest(http.MethodGet, "/api/events"+query, nil)); got != http.StatusOK {
+		t.Errorf("feed with a query token = %d, want %d", got, http.StatusOK)
+	}
+	if got := serve(s.RequireAdmin(okHandler), httptest.NewRequest(http.MethodGet, "/preview/1"+query, nil)); got != http.StatusUnauthorized {
+		t.Errorf("admin route with a query token = %d, want %d", got, http.StatusUnauthorized)
+	}
+	if got := serve(s.RequireAdminFeed(okHandler), httptest.NewRequest(http.MethodPost, "/api/events"+query, nil)); got != http.StatusUnauthorized {
+		t.Errorf("feed POST with a query token = %d, want %d", got, http.StatusUnauthorized)
+	}
+}
+
+func TestAPITokenStore(t *testing.T) {
+	appDB, err := NewAppDatabase(t.TempDir() + "/app.db")
+	if err != nil {
+		t.Fatalf("NewAppDatabase: %v", err)
+	}
+	defer appDB.Close()
+	tokens := NewAPITokenStore(appDB.GetDB())
+
+	token, err := tokens.CreateToken("obs")
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+	if _, err := tokens.CreateToken("obs"); err == nil {
+		t.Error("CreateToken accepted a duplicate name")
+	}
+
+	// Only the hash is stored, and it is what tokens are looked up by
+	var stored string
+	if err := appDB.GetDB().QueryRow(`SELECT token_hash FROM api_tokens WHERE name = ?`, "obs").Scan(&stored); err != nil {
+		t.Fatalf("query token_hash: %v", err)
+	}
+	if stored == token || stored != hashToken(token) {
+		t.Errorf("token_hash = %q, want the SHA-256 hash of the token", stored)
+	}
+
+	got, err := tokens.Authenticate(token)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if got.Name != "obs" || got.LastUsedAt == nil {
+		t.Errorf("Authenticate = %+v, want token obs with a last use", got)
+	}
+	if _, err := tokens.Authenticate(stored); !errors.Is(err, errTokenNotFound) {
+		t.Errorf("Authenticate(hash) = %v, want errTokenNotFound", err)
+	}
+
+	if err := tokens.RevokeToken("obs"); err != nil {
+		t.Fatalf("RevokeToken: %v", err)
+	}
+	if _, err := tokens.Authenticate(token); !errors.Is(err, errTokenNotFound) {
+		t.Errorf("Authenticate after revoking = %v, want errTokenNotFound", err)
+	}
+	if err := tokens.RevokeToken("obs"); !errors.Is(err, errTokenNotFound) {
+		t.Errorf("revoking twice = %v, want errTokenNotFound", err)
+	}
+}
+
+func TestValidateStateSession(t *testing.T) {
+	s, session, token := newAdminServer(t)
+
+	state, err := s.GenerateState(session)
+	if err != nil {
+		t.Fatalf("GenerateState: %v", err)
+	}
+	if s.ValidateState(state, "another-session") {
+		t.Error("a state was accepted from another session")
+	}
+	state, _ = s.GenerateState(session)
+	if s.ValidateState(state, "") {
+		t.Error("a state was accepted without a session")
+	}
+	state, _ = s.GenerateState(session)
+	if !s.ValidateState(state, session) {
+		t.Error("a state was rejected from the session that started it")
+	}
+	if s.ValidateState(state, session) {
+		t.Error("a state was accepted twice")
+	}
+	state, _ = s.GenerateState("")
+	if s.ValidateState(state, "") {
+		t.Error("a state without a session was accepted")
+	}
+
+	// The flow can't be started with an API token
+	if got := serve(s.RequireAdmin(s.HandleLogin), withBearer(httptest.NewRequest(http.MethodGet, "/login", nil), token)); got != http.StatusForbidden {
+		t.Errorf("/login with an API token = %d, want %d", got, http.StatusForbidden)
+	}
+	if got := serve(s.RequireAdmin(s.HandleLogin), withSession(httptest.NewRequest(http.MethodGet, "/login", nil), session)); got != http.StatusTemporaryRedirect {
+		t.Errorf("/login with a session = %d, want %d", got, http.StatusTemporaryRedirect)
+	}
+}
+
+func TestReconnectWithoutCredentials(t *testing.T) {
+	s, _, _ := newAdminServer(t)
+
+	w := httptest.NewRecorder()
+	s.HandleReconnect(w, httptest.NewRequest(http.MethodPost, "/reconnect", nil))
+	notice := w.Header().Get("Location")
+	if w.Code != http.StatusSeeOther || !strings.Contains(notice, "notice=Sign+in") {
+		t.Errorf("reconnect without credentials = %d to %q, want a redirect asking to sign in", w.Code, notice)
+	}
+
+	s.connMutex.RLock()
+	started := s.connCancel != nil
+	s.connMutex.RUnlock()
+	if started {
+		t.Error("reconnect without credentials started the WebSocket connection")
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultAPIEventLimit is how many events a page of /api/events holds by default
+	defaultAPIEventLimit = 50
+	// maxAPIEventLimit caps the limit parameter of /api/events
+	maxAPIEventLimit = 500
+)
+
+// APIEvent is a stored stream event as served by the JSON API
+type APIEvent struct {
+	ID          int64     `json:"id"`
+	ReceivedAt  time.Time `json:"received_at"`
+	Type        string    `json:"type"`
+	User        *string   `json:"user"`
+	MessageKey  *string   `json:"message_key"`
+	PrintStatus *string   `json:"print_status"`
+	PrintReason *string   `json:"print_reason"`
+	Text        string    `json:"text"`
+	CreatedAt   string    `json:"created_at,omitempty"`
+	// Metadata is the decoded metadata object of the event, nil if it has none
+	Metadata map[string]interface{} `json:"metadata"`
+	// MetadataError explains why metadata is missing when it didn't decode
+	MetadataError string `json:"metadata_error,omitempty"`
+	RawJSON       string `json:"raw_json"`
+}
+
+// newAPIEvent converts a stored stream event, decoding its frame for the
+// message text and metadata
+func newAPIEvent(se *StreamEvent) APIEvent {
+	ae := APIEvent{
+		ID:          se.ID,
+		ReceivedAt:  se.ReceivedTimestamp.UTC(),
+		Type:        se.EventType,
+		User:        se.UserWhoPerformedAction,
+		MessageKey:  se.MessageKey,
+		PrintStatus: se.PrintStatus,
+		PrintReason: se.PrintReason,
+		RawJSON:     se.RawJSON,
+	}
+
+	frame, err := DecodeGatewayMessage([]byte(se.RawJSON))
+	if err != nil {
+		ae.MetadataError = err.Error()
+		return ae
+	}
+	ev, err := DecodeEvent(frame)
+	if err != nil {
+		ae.MetadataError = err.Error()
+	}
+	if ev == nil {
+		return ae
+	}
+	ae.Text = ev.Text()
+	ae.CreatedAt = ev.CreatedAt()
+	if ev.Metadata != nil {
+		ae.Metadata = ev.Metadata.Fields
+	}
+	return ae
+}
+
+// apiEventsResponse is a page of /api/events
+type apiEventsResponse struct {
+	Events []APIEvent `json:"events"`
+	// NextCursor is passed as cursor to get the next page; omitted on the last page
+	NextCursor int64 `json:"next_cursor,omitempty"`
+}
+
+// HandleAPIEvents serves stored stream events as JSON, newest first.
+//
+// Query parameters:
+//   - type: event type, repeated or comma separated for several types
+//   - user: user who performed the action
+//   - since, until: received time range, RFC 3339 or unix seconds; until is exclusive
+//   - q: text searched in the message text and metadata
+//   - cursor: next_cursor of the previous page
+//   - limit: events per page, 1 to 500 (default 50)
+func (s *Server) HandleAPIEvents(w http.ResponseWriter, r *http.Request) {
+	if s.eventStore == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "stream event store is not initialized")
+		return
+	}
+
+	query, err := eventQueryFromRequest(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Fetch one extra event to know whether there is a next page
+	limit := query.Limit
+	query.Limit++
+	events, err := s.eventStore.QueryEvents(query)
+	if err != nil {
+		log.Printf("⚠️  Failed to query events: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to query events")
+		return
+	}
+
+	resp := apiEventsResponse{Events: make([]APIEvent, 0, len(events))}
+	if len(events) > limit {
+		events = events[:limit]
+		resp.NextCursor = events[limit-1].ID
+	}
+	for i := range events {
+		resp.Events = append(resp.Events, newAPIEvent(&events[i]))
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// eventQueryFromRequest reads the /api/events query parameters
+func eventQueryFromRequest(r *http.Request) (EventQuery, error) {
+	params := r.URL.Query()
+	query := EventQuery{
+		User:  params.Get("user"),
+		Text:  params.Get("q"),
+		Limit: defaultAPIEventLimit,
+	}
+
+	for _, v := range params["type"] {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				query.Types = append(query.Types, t)
+			}
+		}
+	}
+
+	var err error
+	if query.Since, err = parseQueryTime(params.Get("since")); err != nil {
+		return query, fmt.Errorf("invalid since: %w", err)
+	}
+	if query.Until, err = parseQueryTime(params.Get("until")); err != nil {
+		return query, fmt.Errorf("invalid until: %w", err)
+	}
+
+	if v := params.Get("cursor"); v != "" {
+		query.BeforeID, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || query.BeforeID < 1 {
+			return query, fmt.Errorf("invalid cursor: %q", v)
+		}
+	}
+
+	if v := params.Get("limit"); v != "" {
+		query.Limit, err = strconv.Atoi(v)
+		if err != nil || query.Limit < 1 || query.Limit > maxAPIEventLimit {
+			return query, fmt.Errorf("invalid limit: %q (must be 1 to %d)", v, maxAPIEventLimit)
+		}
+	}
+
+	return query, nil
+}
+
+// parseQueryTime parses an RFC 3339 time or unix seconds; "" is the zero time
+func parseQueryTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if unix, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither RFC 3339 nor unix seconds", v)
+	}
+	return t, nil
+}
+
+// writeJSON writes v as the JSON response body
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("⚠️  Failed to write JSON response: %v", err)
+	}
+}
+
+// writeJSONError writes an error as a JSON object with an error field
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
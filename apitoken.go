@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// errTokenNotFound is returned when no API token matches
+var errTokenNotFound = errors.New("api token not found")
+
+// APIToken is a bearer token for the HTTP interface. Only its hash is stored.
+type APIToken struct {
+	ID         int64
+	Name       string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+// APITokenStore manages the api_tokens table
+type APITokenStore struct {
+	db *sql.DB
+}
+
+// NewAPITokenStore creates a new API token store with a database connection
+func NewAPITokenStore(db *sql.DB) *APITokenStore {
+	return &APITokenStore{
+		db: db,
+	}
+}
+
+// hashToken returns the hex SHA-256 hash a token is stored as
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateToken generates a new token with a unique name and returns it. The
+// token itself isn't stored, so this is the only time it is available.
+func (ts *APITokenStore) CreateToken(name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("token name is required")
+	}
+
+	var exists bool
+	if err := ts.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM api_tokens WHERE name = ?)`, name).Scan(&exists); err != nil {
+		return "", fmt.Errorf("failed to query api tokens: %w", err)
+	}
+	if exists {
+		return "", fmt.Errorf("a token named %q already exists", name)
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	_, err := ts.db.Exec(`
+		INSERT INTO api_tokens (name, token_hash, created_timestamp)
+		VALUES (?, ?, ?)
+	`, name, hashToken(token), time.Now().Unix())
+	if err != nil {
+		return "", fmt.Errorf("failed to store token: %w", err)
+	}
+	return token, nil
+}
+
+// Authenticate looks up a token and records that it was used.
+// Returns errTokenNotFound for unknown or revoked tokens.
+func (ts *APITokenStore) Authenticate(token string) (*APIToken, error) {
+	if token == "" {
+		return nil, errTokenNotFound
+	}
+
+	var t APIToken
+	var created int64
+	var lastUsed sql.NullInt64
+	err := ts.db.QueryRow(`
+		SELECT id, name, created_timestamp, last_used_timestamp
+		FROM api_tokens
+		WHERE token_hash = ?
+	`, hashToken(token)).Scan(&t.ID, &t.Name, &created, &lastUsed)
+	if err == sql.ErrNoRows {
+		return nil, errTokenNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query api token: %w", err)
+	}
+	t.CreatedAt = time.Unix(created, 0)
+
+	now := time.Now()
+	if _, err := ts.db.Exec(`UPDATE api_tokens SET last_used_timestamp = ? WHERE id = ?`, now.Unix(), t.ID); err != nil {
+		log.Printf("⚠️  Failed to record use of api token %s: %v", t.Name, err)
+	}
+	t.LastUsedAt = &now
+	return &t, nil
+}
+
+// ListTokens returns every token, oldest first
+func (ts *APITokenStore) ListTokens() ([]APIToken, error) {
+	rows, err := ts.db.Query(`
+		SELECT id, name, created_timestamp, last_used_timestamp
+		FROM api_tokens
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query api tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		var t APIToken
+		var created int64
+		var lastUsed sql.NullInt64
+		if err := rows.Scan(&t.ID, &t.Name, &created, &lastUsed); err != nil {
+			return nil, fmt.Errorf("failed to scan api token: %w", err)
+		}
+		t.CreatedAt = time.Unix(created, 0)
+		if lastUsed.Valid {
+			used := time.Unix(lastUsed.Int64, 0)
+			t.LastUsedAt = &used
+		}
+		tokens = append(tokens, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating api tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+// RevokeToken deletes a token by name. Returns errTokenNotFound if there is none.
+func (ts *APITokenStore) RevokeToken(name string) error {
+	result, err := ts.db.Exec(`DELETE FROM api_tokens WHERE name = ?`, name)
+	if err != nil {
+		return fmt.Errorf("failed to revoke api token: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return errTokenNotFound
+	}
+	return nil
+}
+
+// CountTokens returns how many tokens exist
+func (ts *APITokenStore) CountTokens() (int, error) {
+	var n int
+	if err := ts.db.QueryRow(`SELECT COUNT(*) FROM api_tokens`).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count api tokens: %w", err)
+	}
+	return n, nil
+}
+
+// runTokens manages API tokens from the command line:
+//
+//	tokens [-db app.db] create NAME
+//	tokens [-db app.db] list
+//	tokens [-db app.db] revoke NAME
+func runTokens(args []string) {
+	fs := flag.NewFlagSet("tokens", flag.ExitOnError)
+	dbPath := fs.String("db", "./app.db", "application database")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s tokens [-db app.db] create NAME | list | revoke NAME\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	command := fs.Arg(0)
+	name := fs.Arg(1)
+	if command == "" || ((command == "create" || command == "revoke") && name == "") {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	appDB, err := NewAppDatabase(*dbPath)
+	if err != nil {
+		log.Fatalf("❌ Failed to open database: %v", err)
+	}
+	defer appDB.Close()
+	tokens := NewAPITokenStore(appDB.GetDB())
+
+	switch command {
+	case "create":
+		token, err := tokens.CreateToken(name)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		fmt.Printf("Created token %q. It is shown only once:\n%s\n", name, token)
+	case "list":
+		list, err := tokens.ListTokens()
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		if len(list) == 0 {
+			fmt.Println("No tokens")
+		}
+		for _, t := range list {
+			lastUsed := "never used"
+			if t.LastUsedAt != nil {
+				lastUsed = "last used " + t.LastUsedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("  %-20s created %s, %s\n", t.Name, t.CreatedAt.Format(time.RFC3339), lastUsed)
+		}
+	case "revoke":
+		if err := tokens.RevokeToken(name); err != nil {
+			log.Fatalf("❌ Failed to revoke %q: %v", name, err)
+		}
+		fmt.Printf("Revoked token %q\n", name)
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+}
@@ -1,17 +1,16 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 )
 
@@ -37,6 +36,14 @@ func (s *Server) LoadCredentials() error {
 	return nil
 }
 
+// hasCredentials reports whether the bot is signed in and has the client
+// credentials the gateway connection authenticates with
+func (s *Server) hasCredentials() bool {
+	s.credMutex.RLock()
+	defer s.credMutex.RUnlock()
+	return s.credentials.AccessToken != "" && s.credentials.ClientID != "" && s.credentials.ClientSecret != ""
+}
+
 // SaveCredentials persists credentials to file
 func (s *Server) SaveCredentials() error {
 	s.credMutex.RLock()
@@ -62,8 +69,9 @@ func (s *Server) SaveCredentials() error {
 	return nil
 }
 
-// GenerateState creates a random state string for OAuth CSRF protection
-func (s *Server) GenerateState() (string, error) {
+// GenerateState creates a random state string for OAuth CSRF protection, bound
+// to the admin session starting the flow
+func (s *Server) GenerateState(session string) (string, error) {
 	b := make([]byte, 32)
 	if _, err := rand.Read(b); err != nil {
 		return "", err
@@ -72,7 +80,7 @@ func (s *Server) GenerateState() (string, error) {
 
 	s.statesMutex.Lock()
 	defer s.statesMutex.Unlock()
-	s.authStates[state] = AuthState{State: state, Created: time.Now()}
+	s.authStates[state] = AuthState{State: state, Created: time.Now(), Session: session}
 
 	// Clean old states (older than 10 minutes)
 	for k, v := range s.authStates {
@@ -84,8 +92,9 @@ func (s *Server) GenerateState() (string, error) {
 	return state, nil
 }
 
-// ValidateState checks if the provided state is valid and removes it
-func (s *Server) ValidateState(state string) bool {
+// ValidateState checks if the provided state is valid and removes it. The flow
+// must complete in the admin session that started it.
+func (s *Server) ValidateState(state, session string) bool {
 	s.statesMutex.Lock()
 	defer s.statesMutex.Unlock()
 
@@ -101,12 +110,20 @@ func (s *Server) ValidateState(state string) bool {
 	}
 
 	delete(s.authStates, state)
-	return true
+	return authState.Session != "" && authState.Session == session
 }
 
-// HandleLogin initiates the OAuth flow
+// HandleLogin initiates the OAuth flow. It needs an admin session, since the
+// callback is a browser redirect that only carries the session cookie; an API
+// token can't complete the flow, so it can't start one either.
 func (s *Server) HandleLogin(w http.ResponseWriter, r *http.Request) {
-	state, err := s.GenerateState()
+	session := s.adminSession(r)
+	if session == "" {
+		http.Error(w, "Sign in with the admin password to connect Joystick TV", http.StatusForbidden)
+		return
+	}
+
+	state, err := s.GenerateState(session)
 	if err != nil {
 		log.Printf("❌ Failed to generate state: %v", err)
 		http.Error(w, "Failed to generate state", http.StatusInternalServerError)
@@ -114,12 +131,7 @@ func (s *Server) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Redirect to Joystick TV OAuth authorization endpoint
-	authURL := fmt.Sprintf(
-		"https://joystick.tv/api/oauth/authorize?client_id=%s&redirect_uri=%s&state=%s&response_type=code&scope=bot",
-		s.clientID,
-		s.redirectURL,
-		state,
-	)
+	authURL := s.joystick.AuthorizeURL(state)
 
 	log.Printf("ℹ️  Redirecting to authorization endpoint with state: %s", state)
 	http.Redirect(w, r, authURL, http.StatusTemporaryRedirect)
@@ -136,7 +148,8 @@ func (s *Server) HandleCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if state == "" || !s.ValidateState(state) {
+	// Only flows started from /login, which requires an admin, have a state
+	if state == "" || !s.ValidateState(state, s.adminSession(r)) {
 		log.Printf("❌ Invalid or missing state in callback")
 		http.Error(w, "Invalid state parameter", http.StatusBadRequest)
 		return
@@ -155,13 +168,12 @@ func (s *Server) HandleCallback(w http.ResponseWriter, r *http.Request) {
 	if err := s.SaveCredentials(); err != nil {
 		log.Printf("⚠️  Credentials received but failed to persist: %v", err)
 	}
+	s.notifyCredentialsChanged()
 
 	// Start WebSocket connection in background
 	go func() {
 		time.Sleep(1 * time.Second) // Give user time to see success page
-		if err := s.ConnectToWebSocket(); err != nil {
-			log.Printf("❌ WebSocket connection error: %v", err)
-		}
+		s.StartWebSocket()
 	}()
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -187,48 +199,14 @@ func (s *Server) HandleCallback(w http.ResponseWriter, r *http.Request) {
 
 // ExchangeCodeForToken exchanges an authorization code for an access token
 func (s *Server) ExchangeCodeForToken(code string) error {
-	basicAuth := base64.StdEncoding.EncodeToString(
-		[]byte(s.clientID + ":" + s.clientSecret),
-	)
-
 	data := url.Values{}
 	data.Set("grant_type", "authorization_code")
 	data.Set("code", code)
 	data.Set("redirect_uri", s.redirectURL)
-	reqBody := data.Encode()
-
-	req, err := http.NewRequest(
-		"POST",
-		"https://joystick.tv/api/oauth/token",
-		strings.NewReader(reqBody),
-	)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Authorization", "Basic "+basicAuth)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	resp, err := http.DefaultClient.Do(req)
+	tokenResp, err := s.joystick.RequestToken(context.Background(), data)
 	if err != nil {
-		return fmt.Errorf("failed to request token: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("token request failed with status %d: %s", resp.StatusCode, string(body))
-	}
-
-	var tokenResp struct {
-		AccessToken  string `json:"access_token"`
-		RefreshToken string `json:"refresh_token"`
-		ExpiresIn    int    `json:"expires_in"`
-		TokenType    string `json:"token_type"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
-		return fmt.Errorf("failed to parse token response: %w", err)
+		return err
 	}
 
 	s.credMutex.Lock()
@@ -244,63 +222,6 @@ func (s *Server) ExchangeCodeForToken(code string) error {
 	return nil
 }
 
-// HandleStatus returns the current authentication status
-func (s *Server) HandleStatus(w http.ResponseWriter, r *http.Request) {
-	s.credMutex.RLock()
-	defer s.credMutex.RUnlock()
-
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-
-	isAuthenticated := s.credentials.AccessToken != ""
-	isExpired := !s.credentials.ExpiresAt.IsZero() && time.Now().After(s.credentials.ExpiresAt)
-
-	statusHTML := `
-		<!DOCTYPE html>
-		<html>
-		<head>
-			<title>Authentication Status</title>
-			<style>
-				body { font-family: Arial, sans-serif; margin: 50px; }
-				.authenticated { color: #27ae60; }
-				.expired { color: #e74c3c; }
-				.status-box { border: 1px solid #ccc; padding: 20px; border-radius: 5px; margin: 20px 0; }
-				a { color: #3498db; text-decoration: none; }
-				a:hover { text-decoration: underline; }
-			</style>
-		</head>
-		<body>
-			<h1>Joystick TV Authentication Status</h1>
-			<div class="status-box">
-	`
-
-	if !isAuthenticated {
-		statusHTML += `
-			<p><strong>Status:</strong> <span style="color: #95a5a6;">Not Authenticated</span></p>
-			<p><a href="/login">Click here to authenticate</a></p>
-		`
-	} else if isExpired {
-		statusHTML += `
-			<p><strong>Status:</strong> <span class="expired">Token Expired</span></p>
-			<p>Access token expired at: ` + s.credentials.ExpiresAt.Format(time.RFC3339) + `</p>
-			<p><a href="/login">Re-authenticate</a></p>
-		`
-	} else {
-		statusHTML += `
-			<p><strong>Status:</strong> <span class="authenticated">✓ Authenticated</span></p>
-			<p><strong>Expires At:</strong> ` + s.credentials.ExpiresAt.Format(time.RFC3339) + `</p>
-			<p><strong>Client ID:</strong> ` + maskString(s.credentials.ClientID) + `</p>
-		`
-	}
-
-	statusHTML += `
-			</div>
-		</body>
-		</html>
-	`
-
-	fmt.Fprint(w, statusHTML)
-}
-
 // maskString masks a string for display (shows first 4 and last 4 chars)
 func maskString(s string) string {
 	if len(s) <= 8 {
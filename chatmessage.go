@@ -0,0 +1,215 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ChatMessageRecord is a chat message stored in the database
+type ChatMessageRecord struct {
+	ID                int64
+	ReceivedTimestamp time.Time
+	CreatedTimestamp  *time.Time // from the gateway createdAt, if it parsed
+	MessageKey        *string
+	ChannelID         *string
+	Username          string  // author slug, falling back to the username
+	DisplayName       *string // author name as shown in chat
+	Text              string
+	BotCommand        *string
+	BotCommandArg     *string
+	Mention           bool
+	MentionedUsername *string
+	EmotesJSON        *string // emotesUsed as received, a JSON array
+	Visibility        *string
+	RawJSON           string
+}
+
+// ChatMessageStore stores chat messages in the chat_messages table
+type ChatMessageStore struct {
+	db *sql.DB
+}
+
+// NewChatMessageStore creates a new chat message store with a database connection
+func NewChatMessageStore(db *sql.DB) *ChatMessageStore {
+	return &ChatMessageStore{
+		db: db,
+	}
+}
+
+// chatMessageColumns are the columns read into a ChatMessageRecord, in scan order
+const chatMessageColumns = `id, received_timestamp, created_timestamp, message_key, channel_id, username, display_name, text,
+	bot_command, bot_command_arg, mention, mentioned_username, emotes_json, visibility, raw_json`
+
+// StoreMessage stores a chat message and returns its row id. Only stores
+// ChatMessage events. Returns errDuplicateEvent if the message was stored before.
+func (cms *ChatMessageStore) StoreMessage(ev *Event) (int64, error) {
+	if ev.Kind != KindChatMessage || ev.Chat == nil {
+		return 0, nil
+	}
+	chat := ev.Chat
+
+	var emotes *string
+	if len(chat.EmotesUsed) > 0 {
+		data, err := json.Marshal(chat.EmotesUsed)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode emotes: %w", err)
+		}
+		emotesJSON := string(data)
+		emotes = &emotesJSON
+	}
+
+	username := ev.User()
+	if username == "" {
+		username = "unknown"
+	}
+	var displayName *string
+	if name := chat.Author.DisplayName(); name != "" {
+		displayName = &name
+	}
+
+	result, err := cms.db.Exec(`
+		INSERT INTO chat_messages (received_timestamp, created_timestamp, message_key, channel_id, username, display_name, text,
+			bot_command, bot_command_arg, mention, mentioned_username, emotes_json, visibility, raw_json)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (message_key) DO NOTHING
+	`,
+		ev.ReceivedAt.Unix(),
+		createdTimestamp(ev),
+		nullString(ev.MessageKey()),
+		nullString(string(chat.ChannelID)),
+		username,
+		displayName,
+		chat.Text,
+		nullString(chat.BotCommand),
+		nullString(chat.BotCommandArg),
+		chat.Mention,
+		nullString(chat.MentionedUsername),
+		emotes,
+		nullString(chat.Visibility),
+		string(ev.Frame.Raw),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert chat message: %w", err)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return 0, errDuplicateEvent
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get chat message id: %w", err)
+	}
+	return id, nil
+}
+
+// GetMessagesByUser retrieves the most recent chat messages of a user, matching the name in any case
+func (cms *ChatMessageStore) GetMessagesByUser(user string, limit int) ([]ChatMessageRecord, error) {
+	rows, err := cms.db.Query(`
+		SELECT `+chatMessageColumns+`
+		FROM chat_messages
+		WHERE username = ? COLLATE NOCASE
+		ORDER BY received_timestamp DESC, id DESC
+		LIMIT ?
+	`, user, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query chat messages: %w", err)
+	}
+	return scanChatMessages(rows)
+}
+
+// GetMentions retrieves the most recent chat messages mentioning the streamer
+func (cms *ChatMessageStore) GetMentions(limit int) ([]ChatMessageRecord, error) {
+	rows, err := cms.db.Query(`
+		SELECT `+chatMessageColumns+`
+		FROM chat_messages
+		WHERE mention = 1
+		ORDER BY received_timestamp DESC, id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query chat messages: %w", err)
+	}
+	return scanChatMessages(rows)
+}
+
+// GetRecentMessages retrieves the most recent chat messages
+func (cms *ChatMessageStore) GetRecentMessages(limit int) ([]ChatMessageRecord, error) {
+	rows, err := cms.db.Query(`
+		SELECT `+chatMessageColumns+`
+		FROM chat_messages
+		ORDER BY received_timestamp DESC, id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query chat messages: %w", err)
+	}
+	return scanChatMessages(rows)
+}
+
+// scanChatMessages reads chat message rows and closes them
+func scanChatMessages(rows *sql.Rows) ([]ChatMessageRecord, error) {
+	defer rows.Close()
+
+	var messages []ChatMessageRecord
+	for rows.Next() {
+		var msg ChatMessageRecord
+		var received int64
+		var created sql.NullInt64
+
+		err := rows.Scan(
+			&msg.ID,
+			&received,
+			&created,
+			&msg.MessageKey,
+			&msg.ChannelID,
+			&msg.Username,
+			&msg.DisplayName,
+			&msg.Text,
+			&msg.BotCommand,
+			&msg.BotCommandArg,
+			&msg.Mention,
+			&msg.MentionedUsername,
+			&msg.EmotesJSON,
+			&msg.Visibility,
+			&msg.RawJSON,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan chat message: %w", err)
+		}
+
+		msg.ReceivedTimestamp = time.Unix(received, 0)
+		if created.Valid {
+			t := time.Unix(created.Int64, 0)
+			msg.CreatedTimestamp = &t
+		}
+		messages = append(messages, msg)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating chat messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+// createdTimestamp returns the gateway createdAt of an event as a unix
+// timestamp, or nil when it is missing or doesn't parse
+func createdTimestamp(ev *Event) *int64 {
+	t, err := time.Parse(time.RFC3339, ev.CreatedAt())
+	if err != nil {
+		return nil
+	}
+	unix := t.Unix()
+	return &unix
+}
+
+// nullString returns nil for an empty string, so it is stored as NULL
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,131 @@
+// Command fake-joystick runs a stand-in Joystick TV server for demos and manual
+// testing of the bot without a live stream.
+//
+// Start it and point the bot at it:
+//
+//	go run ./cmd/fake-joystick -addr :9000
+//	JOYSTICK_BASE_URL=http://localhost:9000 JOYSTICK_CLIENT_ID=fake JOYSTICK_CLIENT_SECRET=fake go run .
+//
+// Events can be injected while the bot is connected:
+//
+//	curl -X POST 'http://localhost:9000/fake/tip?who=alice&amount=100&item=Hydrate'
+//	curl -X POST 'http://localhost:9000/fake/follow?who=bob'
+//	curl -X POST 'http://localhost:9000/fake/send' -d '{"event":"ChatMessage","text":"hi"}'
+//	curl -X POST 'http://localhost:9000/fake/drop'
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/tyrm/joysticktv-receipt-bot/fakejoystick"
+)
+
+func main() {
+	addr := flag.String("addr", ":9000", "address to listen on")
+	clientID := flag.String("client-id", "fake", "accepted OAuth client ID")
+	clientSecret := flag.String("client-secret", "fake", "accepted OAuth client secret")
+	ping := flag.Duration("ping", 3*time.Second, "interval between gateway pings (0 disables)")
+	expiresIn := flag.Int("expires-in", 3600, "lifetime of issued access tokens in seconds")
+	scriptFile := flag.String("script", "", "JSON lines file of {\"delay\":\"1s\",\"message\":{...}} played to each connection")
+	flag.Parse()
+
+	fs := fakejoystick.New(*clientID, *clientSecret)
+	fs.PingInterval = *ping
+	fs.ExpiresIn = *expiresIn
+
+	if *scriptFile != "" {
+		script, err := loadScript(*scriptFile)
+		if err != nil {
+			log.Fatalf("❌ Failed to load script: %v", err)
+		}
+		fs.Script = script
+		log.Printf("ℹ️  Loaded %d scripted frame(s) from %s", len(script), *scriptFile)
+	}
+
+	fs.Handle("/fake/send", postOnly(func(w http.ResponseWriter, r *http.Request) {
+		var message map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+			http.Error(w, fmt.Sprintf("invalid message: %v", err), http.StatusBadRequest)
+			return
+		}
+		fs.Send(fakejoystick.Message(message))
+	}))
+	fs.Handle("/fake/tip", postOnly(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		amount, _ := strconv.Atoi(q.Get("amount"))
+		fs.Send(fakejoystick.Tipped(q.Get("who"), amount, q.Get("item"), q.Get("text")))
+	}))
+	fs.Handle("/fake/follow", postOnly(func(w http.ResponseWriter, r *http.Request) {
+		fs.Send(fakejoystick.Followed(r.URL.Query().Get("who")))
+	}))
+	fs.Handle("/fake/subscribe", postOnly(func(w http.ResponseWriter, r *http.Request) {
+		fs.Send(fakejoystick.Subscribed(r.URL.Query().Get("who")))
+	}))
+	fs.Handle("/fake/chat", postOnly(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		who := q.Get("who")
+		fs.Send(fakejoystick.ChatMessage(fakejoystick.Author{Slug: who, Username: who}, q.Get("text")))
+	}))
+	fs.Handle("/fake/drop", postOnly(func(w http.ResponseWriter, r *http.Request) {
+		fs.DropConnections()
+	}))
+
+	log.Printf("✓ Fake Joystick TV listening on %s (client id %q)", *addr, *clientID)
+	if err := http.ListenAndServe(*addr, fs); err != nil {
+		log.Fatalf("❌ Server failed: %v", err)
+	}
+}
+
+// postOnly wraps a control endpoint, rejecting other methods and logging the call
+func postOnly(fn http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		log.Printf("📨 %s %s", r.URL.Path, r.URL.RawQuery)
+		fn(w, r)
+	})
+}
+
+// loadScript reads a JSON lines script file
+func loadScript(path string) ([]fakejoystick.Step, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var script []fakejoystick.Step
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry struct {
+			Delay   string                 `json:"delay"`
+			Message map[string]interface{} `json:"message"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		var delay time.Duration
+		if entry.Delay != "" {
+			if delay, err = time.ParseDuration(entry.Delay); err != nil {
+				return nil, fmt.Errorf("line %d: invalid delay: %w", line, err)
+			}
+		}
+		script = append(script, fakejoystick.Step{Delay: delay, Frame: fakejoystick.Message(entry.Message)})
+	}
+	return script, scanner.Err()
+}
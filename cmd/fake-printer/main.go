@@ -0,0 +1,38 @@
+// Command fake-printer runs a stand-in ESC/POS network printer that logs every
+// receipt it receives instead of printing it.
+//
+//	go run ./cmd/fake-printer -addr :9100
+//	RECEIPT_ADDR=localhost:9100 go run .
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/tyrm/joysticktv-receipt-bot/fakeprinter"
+)
+
+func main() {
+	addr := flag.String("addr", ":9100", "address to listen on")
+	paperOut := flag.Bool("paper-out", false, "report the paper roll as empty to status requests")
+	coverOpen := flag.Bool("cover-open", false, "report the cover as open to status requests")
+	noStatus := flag.Bool("no-status", false, "ignore status requests like a printer without DLE EOT support")
+	flag.Parse()
+
+	fp, err := fakeprinter.Listen(*addr)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	fp.SetStatus(fakeprinter.Status{PaperOut: *paperOut, CoverOpen: *coverOpen, NoAnswer: *noStatus})
+	log.Printf("✓ Fake printer listening on %s", fp.Addr())
+
+	printed := 0
+	for {
+		receipts, _ := fp.WaitForReceipts(printed+1, time.Hour)
+		for _, r := range receipts[printed:] {
+			log.Printf("🧾 Receipt %d (%d image(s), cut: %t):\n%s", printed+1, len(r.Images()), r.Cut, r.Text())
+			printed++
+		}
+	}
+}
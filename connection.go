@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"time"
+)
+
+// ConnectionState describes the state of the Joystick TV WebSocket connection
+type ConnectionState string
+
+const (
+	ConnectionDisconnected ConnectionState = "disconnected"
+	ConnectionConnecting   ConnectionState = "connecting"
+	ConnectionConnected    ConnectionState = "connected"
+	ConnectionReconnecting ConnectionState = "reconnecting"
+	ConnectionStalled      ConnectionState = "stalled"
+	ConnectionGaveUp       ConnectionState = "gave up"
+)
+
+// maxConnectionEvents limits how many connection events are kept in memory
+const maxConnectionEvents = 50
+
+// DefaultHeartbeatTimeout is how long the connection may go without any frame
+// before it is considered dead. ActionCable pings every 3 seconds.
+const DefaultHeartbeatTimeout = 30 * time.Second
+
+// errMissingCredentials is returned when no client credentials are available to connect
+var errMissingCredentials = errors.New("missing credentials for WebSocket connection")
+
+// ReconnectPolicy controls how the WebSocket supervisor retries failed connections
+type ReconnectPolicy struct {
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+	MaxAttempts  int // 0 retries forever
+}
+
+// DefaultReconnectPolicy returns the reconnect policy used when none is configured
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return ReconnectPolicy{
+		InitialDelay: 1 * time.Second,
+		MaxDelay:     2 * time.Minute,
+		Multiplier:   2,
+		MaxAttempts:  0,
+	}
+}
+
+// Delay returns the wait before the given reconnect attempt (starting at 1).
+// The delay grows exponentially up to MaxDelay and is jittered between half
+// and the full value so that restarts don't retry in lockstep.
+func (p ReconnectPolicy) Delay(attempt int) time.Duration {
+	delay := float64(p.InitialDelay)
+	for i := 1; i < attempt && delay < float64(p.MaxDelay); i++ {
+		delay *= p.Multiplier
+	}
+	if delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+
+	half := int64(delay / 2)
+	if half <= 0 {
+		return time.Duration(delay)
+	}
+	return time.Duration(half + rand.Int64N(half+1))
+}
+
+// ConnectionEvent records a notable change of the WebSocket connection
+type ConnectionEvent struct {
+	Time   time.Time
+	State  ConnectionState
+	Detail string
+}
+
+// ConnectionStatus is a snapshot of the WebSocket connection state
+type ConnectionStatus struct {
+	State       ConnectionState
+	Attempt     int
+	LastError   string
+	ConnectedAt time.Time
+	NextRetryAt time.Time
+	LastFrameAt time.Time
+	LastPingAt  time.Time
+	LastEventAt time.Time
+	LastEvent   string // type and user of the last decoded event
+	Events      []ConnectionEvent
+}
+
+// errHeartbeatTimeout is returned when no frame arrives within the heartbeat window
+var errHeartbeatTimeout = errors.New("no heartbeat received")
+
+// isTimeout reports whether err is a network read timeout
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// StartWebSocket starts the supervised WebSocket connection loop, replacing any
+// loop that is already running
+func (s *Server) StartWebSocket() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	s.connMutex.Lock()
+	prevCancel, prevDone := s.connCancel, s.connDone
+	s.connCancel = cancel
+	s.connDone = done
+	s.connMutex.Unlock()
+
+	if prevCancel != nil {
+		prevCancel()
+		<-prevDone
+	}
+
+	go func() {
+		defer close(done)
+		s.superviseWebSocket(ctx)
+	}()
+}
+
+// StopWebSocket stops the supervised WebSocket connection loop and waits for it to exit
+func (s *Server) StopWebSocket() {
+	s.connMutex.Lock()
+	cancel, done := s.connCancel, s.connDone
+	s.connCancel = nil
+	s.connDone = nil
+	s.connMutex.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// superviseWebSocket keeps the WebSocket connected, reconnecting with capped
+// exponential backoff until the context is cancelled or the policy gives up
+func (s *Server) superviseWebSocket(ctx context.Context) {
+	attempt := 0
+	for {
+		if attempt == 0 {
+			s.setConnectionState(ConnectionConnecting, "connecting to Joystick TV")
+		}
+
+		s.connMutex.RLock()
+		lastConnectedAt := s.connStatus.ConnectedAt
+		s.connMutex.RUnlock()
+
+		err := s.ConnectToWebSocket(ctx)
+		if ctx.Err() != nil {
+			s.setConnectionState(ConnectionDisconnected, "connection stopped")
+			return
+		}
+
+		// A connection that got as far as a confirmed subscription resets the backoff
+		s.connMutex.RLock()
+		if s.connStatus.ConnectedAt.After(lastConnectedAt) {
+			attempt = 0
+		}
+		s.connMutex.RUnlock()
+
+		if err == nil {
+			err = errors.New("connection closed")
+		}
+		if errors.Is(err, errMissingCredentials) {
+			s.recordConnectionError(err)
+			s.setConnectionState(ConnectionGaveUp, err.Error())
+			log.Printf("❌ WebSocket connection gave up: %v", err)
+			return
+		}
+
+		attempt++
+		s.recordConnectionError(err)
+		if s.reconnectPolicy.MaxAttempts > 0 && attempt > s.reconnectPolicy.MaxAttempts {
+			s.setConnectionState(ConnectionGaveUp, err.Error())
+			log.Printf("❌ WebSocket connection gave up after %d attempts: %v", attempt-1, err)
+			return
+		}
+
+		delay := s.reconnectPolicy.Delay(attempt)
+		s.connMutex.Lock()
+		s.connStatus.Attempt = attempt
+		s.connStatus.NextRetryAt = time.Now().Add(delay)
+		s.connMutex.Unlock()
+
+		s.setConnectionState(ConnectionReconnecting, err.Error())
+		log.Printf("🔄 WebSocket reconnect attempt %d in %s (last error: %v)", attempt, delay.Round(time.Millisecond), err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.setConnectionState(ConnectionDisconnected, "connection stopped")
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// setConnectionState updates the connection state and records it as a connection event
+func (s *Server) setConnectionState(state ConnectionState, detail string) {
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+
+	s.connStatus.State = state
+	switch state {
+	case ConnectionConnected:
+		s.connStatus.ConnectedAt = time.Now()
+		s.connStatus.Attempt = 0
+		s.connStatus.NextRetryAt = time.Time{}
+	case ConnectionDisconnected, ConnectionGaveUp:
+		s.connStatus.NextRetryAt = time.Time{}
+	}
+
+	s.connStatus.Events = append(s.connStatus.Events, ConnectionEvent{
+		Time:   time.Now(),
+		State:  state,
+		Detail: detail,
+	})
+	if len(s.connStatus.Events) > maxConnectionEvents {
+		s.connStatus.Events = s.connStatus.Events[len(s.connStatus.Events)-maxConnectionEvents:]
+	}
+}
+
+// recordFrame notes that a frame was read from the WebSocket
+func (s *Server) recordFrame(ping bool) {
+	now := time.Now()
+
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+	s.connStatus.LastFrameAt = now
+	if ping {
+		s.connStatus.LastPingAt = now
+	}
+}
+
+// recordEvent notes the last event decoded from the WebSocket
+func (s *Server) recordEvent(ev *Event) {
+	last := eventName(ev)
+	if user := ev.User(); user != "" {
+		last += " from " + user
+	}
+
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+	s.connStatus.LastEventAt = ev.ReceivedAt
+	s.connStatus.LastEvent = last
+}
+
+// recordStall marks the connection as stalled after the heartbeat window passed
+// without any frames, and returns the error that ends the connection
+func (s *Server) recordStall() error {
+	s.connMutex.RLock()
+	lastFrame := s.connStatus.LastFrameAt
+	s.connMutex.RUnlock()
+
+	detail := fmt.Sprintf("no frames for %s", s.heartbeatTimeout)
+	if !lastFrame.IsZero() {
+		detail = fmt.Sprintf("no frames since %s (timeout %s)", lastFrame.Format(time.RFC3339), s.heartbeatTimeout)
+	}
+	s.setConnectionState(ConnectionStalled, detail)
+	log.Printf("⚠️  WebSocket heartbeat missed: %s, forcing reconnect", detail)
+
+	return fmt.Errorf("%w: %s", errHeartbeatTimeout, detail)
+}
+
+// recordConnectionError stores the last connection error for status reporting
+func (s *Server) recordConnectionError(err error) {
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+	s.connStatus.LastError = err.Error()
+}
+
+// ConnectionStatus returns a snapshot of the current WebSocket connection state
+func (s *Server) ConnectionStatus() ConnectionStatus {
+	s.connMutex.RLock()
+	defer s.connMutex.RUnlock()
+
+	status := s.connStatus
+	status.Events = append([]ConnectionEvent(nil), s.connStatus.Events...)
+	if status.State == "" {
+		status.State = ConnectionDisconnected
+	}
+	return status
+}
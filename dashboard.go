@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"image/png"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	receipttemplate "tyr.codes/golib/receipt/template"
+)
+
+// dashboardFiles holds the dashboard page templates
+//
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// dashboardRecentEvents is how many stored events the dashboard lists
+const dashboardRecentEvents = 25
+
+// dashboardTemplates hold the pages of the web interface. They are parsed at
+// startup, so a broken template stops the bot right away instead of failing
+// page views
+var dashboardTemplates = template.Must(template.New("").Funcs(template.FuncMap{
+	"datetime": formatDashboardTime,
+	"ago":      formatAgo,
+	"bytes":    formatBytes,
+	"mask":     maskString,
+	"number":   formatNumber,
+	"deref": func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	},
+}).ParseFS(dashboardFiles, "dashboard/*.html"))
+
+// dashboardAuth is the authentication section of the dashboard
+type dashboardAuth struct {
+	Authenticated bool
+	Expired       bool
+	ExpiresAt     time.Time
+	ClientID      string
+	Refresh       TokenRefreshStatus
+}
+
+// dashboardEvent is a row of the recent events table
+type dashboardEvent struct {
+	StreamEvent
+	Summary string
+}
+
+// dashboardData is everything the dashboard page shows
+type dashboardData struct {
+	Notice     string
+	Now        time.Time
+	SignedIn   bool   // with a session cookie rather than an API token
+	CSRFToken  string // sent with the forms of a signed-in admin
+	Auth       dashboardAuth
+	Connection ConnectionStatus
+
+	Printers        []PrinterStatus
+	QueueLength     int
+	QueueError      string
+	HasPrinter      bool
+	Thumbnails      ThumbnailStats
+	ThumbError      string
+	Events          []dashboardEvent
+	EventsError     string
+	LiveSubscribers int // clients of /events/stream
+}
+
+// HandleDashboard serves the dashboard with the state of the connection,
+// printers, print queue, thumbnail cache and the latest events
+func (s *Server) HandleDashboard(w http.ResponseWriter, r *http.Request) {
+	data := dashboardData{
+		Notice:     r.URL.Query().Get("notice"),
+		Now:        time.Now(),
+		Connection: s.ConnectionStatus(),
+		HasPrinter: s.printer != nil,
+	}
+	if id := s.adminSession(r); id != "" {
+		data.SignedIn = true
+		data.CSRFToken = s.admin.CSRFToken(id)
+	}
+
+	s.credMutex.RLock()
+	data.Auth = dashboardAuth{
+		Authenticated: s.credentials.AccessToken != "",
+		Expired:       !s.credentials.ExpiresAt.IsZero() && time.Now().After(s.credentials.ExpiresAt),
+		ExpiresAt:     s.credentials.ExpiresAt,
+		ClientID:      s.credentials.ClientID,
+	}
+	s.credMutex.RUnlock()
+	data.Auth.Refresh = s.TokenRefreshStatus()
+
+	if s.printers != nil {
+		data.Printers = s.printers.Status()
+	}
+	if s.printQueue != nil {
+		n, err := s.printQueue.PendingCount()
+		if err != nil {
+			data.QueueError = err.Error()
+		}
+		data.QueueLength = n
+	}
+	if s.thumbCache != nil {
+		stats, err := s.thumbCache.Stats()
+		if err != nil {
+			data.ThumbError = err.Error()
+		}
+		data.Thumbnails = stats
+	}
+	if s.eventStore != nil {
+		events, err := s.eventStore.GetRecentEvents(dashboardRecentEvents)
+		if err != nil {
+			data.EventsError = err.Error()
+		}
+		for i := range events {
+			data.Events = append(data.Events, dashboardEvent{
+				StreamEvent: events[i],
+				Summary:     describeStoredEvent(&events[i]),
+			})
+		}
+	}
+	if s.broadcaster != nil {
+		data.LiveSubscribers = s.broadcaster.Subscribers()
+	}
+
+	var page bytes.Buffer
+	if err := dashboardTemplates.ExecuteTemplate(&page, "dashboard.html", data); err != nil {
+		log.Printf("❌ Failed to render dashboard: %v", err)
+		http.Error(w, "Failed to render dashboard", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(page.Bytes())
+}
+
+// HandleReprint runs the handlers of a stored stream event again, printing its
+// receipts through the print queue
+func (s *Server) HandleReprint(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid event id", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.ReprintEvent(id); err != nil {
+		if errors.Is(err, errEventNotFound) {
+			http.Error(w, "Event not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("⚠️  Failed to reprint event %d: %v", id, err)
+		redirectToDashboard(w, r, fmt.Sprintf("Failed to reprint event %d: %v", id, err))
+		return
+	}
+
+	log.Printf("ℹ️  Reprinting event %d", id)
+	redirectToDashboard(w, r, fmt.Sprintf("Event %d sent to the handlers again", id))
+}
+
+// ReprintEvent dispatches a stored stream event to the handlers again. The
+// event's print outcome is updated with the result.
+func (s *Server) ReprintEvent(id int64) error {
+	if s.eventStore == nil {
+		return fmt.Errorf("stream event store is not initialized")
+	}
+	if s.printer == nil {
+		return fmt.Errorf("no printer is configured")
+	}
+
+	stored, err := s.eventStore.GetEventByID(id)
+	if err != nil {
+		return err
+	}
+	if stored == nil {
+		return errEventNotFound
+	}
+
+	ev, err := storedEventToEvent(stored)
+	if err != nil {
+		return err
+	}
+	s.dispatchTo(ev, s.printer, false)
+	return nil
+}
+
+// HandleTestPrint queues a test receipt on the printer named by the printer
+// form value, or the default printer
+func (s *Server) HandleTestPrint(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("printer")
+	if s.printer == nil {
+		redirectToDashboard(w, r, "No printer is configured")
+		return
+	}
+	if name != "" && (s.printers == nil || s.printers.get(name) == nil) {
+		http.Error(w, "Unknown printer", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.printer.PrintReceipt(testReceipt(name)); err != nil {
+		log.Printf("⚠️  Failed to queue test print: %v", err)
+		redirectToDashboard(w, r, "Failed to queue test print: "+err.Error())
+		return
+	}
+
+	if name == "" && s.printers != nil {
+		name = s.printers.Default()
+	}
+	log.Printf("ℹ️  Test print queued for printer %s", name)
+	redirectToDashboard(w, r, "Test print queued for printer "+name)
+}
+
+// testReceipt is the receipt printed by the test print button
+func testReceipt(printer string) *Receipt {
+	logo, err := png.Decode(bytes.NewReader(joysticktv))
+	if err != nil {
+		log.Printf("⚠️  Failed to decode embedded image: %v", err)
+	}
+	return &Receipt{
+		Notification: &receipttemplate.StreamerNotification{
+			Header:   "Test Print",
+			Message:  "Printed from the dashboard\n" + time.Now().Format("2006-01-02 15:04:05"),
+			Image:    logo,
+			Username: "Joystick TV",
+		},
+		Printer: printer,
+		Cut:     true,
+	}
+}
+
+// HandleReconnect restarts the WebSocket connection. Without credentials it
+// only tells the admin to sign in, since the connection would give up at once.
+func (s *Server) HandleReconnect(w http.ResponseWriter, r *http.Request) {
+	if !s.hasCredentials() {
+		redirectToDashboard(w, r, "Sign in to Joystick TV before reconnecting")
+		return
+	}
+
+	log.Printf("ℹ️  Reconnect requested from the dashboard")
+	go s.StartWebSocket()
+	redirectToDashboard(w, r, "Reconnecting to Joystick TV")
+}
+
+// redirectToDashboard sends the browser back to the dashboard after an
+// action, showing notice at the top of the page
+func redirectToDashboard(w http.ResponseWriter, r *http.Request, notice string) {
+	http.Redirect(w, r, "/?notice="+url.QueryEscape(notice), http.StatusSeeOther)
+}
+
+// formatDashboardTime formats a time for the dashboard, or "never" for the zero time
+func formatDashboardTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// formatAgo describes how long ago a time was, rounded to a readable unit
+func formatAgo(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	d := time.Since(t)
+	switch {
+	case d < 0:
+		return "in " + formatDuration(-d)
+	case d < time.Second:
+		return "just now"
+	}
+	return formatDuration(d) + " ago"
+}
+
+// formatDuration rounds a duration to its largest unit
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return strconv.Itoa(int(d.Seconds())) + "s"
+	case d < time.Hour:
+		return strconv.Itoa(int(d.Minutes())) + "m"
+	case d < 48*time.Hour:
+		return strconv.Itoa(int(d.Hours())) + "h"
+	}
+	return strconv.Itoa(int(d.Hours()/24)) + "d"
+}
+
+// formatBytes formats a size in bytes with a binary unit
+func formatBytes(n int64) string {
+	switch {
+	case n < 1024:
+		return strconv.FormatInt(n, 10) + " B"
+	case n < 1024*1024:
+		return fmt.Sprintf("%.1f KiB", float64(n)/1024)
+	}
+	return fmt.Sprintf("%.1f MiB", float64(n)/(1024*1024))
+}
@@ -13,16 +13,39 @@ type AppDatabase struct {
 	db *sql.DB
 }
 
-// NewAppDatabase initializes a new application database
+// NewAppDatabase opens the application database and applies pending schema migrations
 func NewAppDatabase(dbPath string) (*AppDatabase, error) {
-	// Open or create SQLite database
-	db, err := sql.Open("sqlite", dbPath)
+	db, err := openDatabase(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	appDB := &AppDatabase{
+		db: db,
+	}
+
+	// Bring the schema up to date
+	if err := appDB.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate schema: %w", err)
+	}
+
+	return appDB, nil
+}
+
+// openDatabase opens or creates the SQLite database without touching the schema
+func openDatabase(dbPath string) (*sql.DB, error) {
+	// Open or create SQLite database. The busy timeout is set on every pooled
+	// connection so concurrent writers (event store, print queue) wait for each
+	// other instead of failing with SQLITE_BUSY.
+	db, err := sql.Open("sqlite", dbPath+"?_pragma=busy_timeout(5000)")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database %s: %w", dbPath, err)
 	}
 
 	// Test the connection
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
@@ -34,52 +57,7 @@ func NewAppDatabase(dbPath string) (*AppDatabase, error) {
 		log.Printf("⚠️  Warning: Could not set synchronous mode: %v", err)
 	}
 
-	appDB := &AppDatabase{
-		db: db,
-	}
-
-	// Initialize database schema
-	if err := appDB.initSchema(); err != nil {
-		return nil, fmt.Errorf("failed to initialize schema: %w", err)
-	}
-
-	return appDB, nil
-}
-
-// initSchema creates all application tables if they don't exist
-func (ad *AppDatabase) initSchema() error {
-	schema := `
-	-- Thumbnails table for caching user profile images
-	CREATE TABLE IF NOT EXISTS thumbnails (
-		username TEXT PRIMARY KEY NOT NULL,
-		sha256 TEXT NOT NULL,
-		file_size INTEGER NOT NULL,
-		download_timestamp INTEGER NOT NULL,
-		image_url TEXT NOT NULL,
-		file_extension TEXT NOT NULL DEFAULT '.png'
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_download_timestamp ON thumbnails(download_timestamp);
-
-	-- Stream events table for storing all received WebSocket events
-	CREATE TABLE IF NOT EXISTS stream_events (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		received_timestamp INTEGER NOT NULL,
-		event_type TEXT NOT NULL,
-		user_who_performed_action TEXT,
-		raw_json TEXT NOT NULL
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_stream_events_timestamp ON stream_events(received_timestamp);
-	CREATE INDEX IF NOT EXISTS idx_stream_events_type ON stream_events(event_type);
-	CREATE INDEX IF NOT EXISTS idx_stream_events_user ON stream_events(user_who_performed_action);
-	`
-
-	if _, err := ad.db.Exec(schema); err != nil {
-		return fmt.Errorf("failed to create schema: %w", err)
-	}
-
-	return nil
+	return db, nil
 }
 
 // GetDB returns the underlying database connection for use by other components
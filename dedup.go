@@ -0,0 +1,39 @@
+package main
+
+import "sync"
+
+// maxRecentEvents bounds how many message keys are remembered for deduplication
+const maxRecentEvents = 1000
+
+// recentEvents remembers the message keys of the most recent events, so a
+// message the gateway delivers twice (for example after a reconnect) is only
+// handled once. Stream events are also deduplicated by the unique index on
+// stream_events, which survives restarts.
+type recentEvents struct {
+	mu    sync.Mutex
+	seen  map[string]struct{}
+	order []string // oldest first
+	size  int
+}
+
+// newRecentEvents creates a set remembering up to size keys
+func newRecentEvents(size int) *recentEvents {
+	return &recentEvents{seen: make(map[string]struct{}), size: size}
+}
+
+// Add records a key and reports whether it wasn't seen before
+func (re *recentEvents) Add(key string) bool {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+
+	if _, ok := re.seen[key]; ok {
+		return false
+	}
+	re.seen[key] = struct{}{}
+	re.order = append(re.order, key)
+	if len(re.order) > re.size {
+		delete(re.seen, re.order[0])
+		re.order = re.order[1:]
+	}
+	return true
+}
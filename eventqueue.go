@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// eventBacklogWarning is how many decoded events may wait for the event
+// worker before a warning is logged
+const eventBacklogWarning = 256
+
+// eventQueue hands decoded events from the WebSocket reader to the event
+// worker in arrival order. It has no fixed capacity, so the reader never waits
+// for the worker and a slow handler can't stall the heartbeat.
+type eventQueue struct {
+	mu      sync.Mutex
+	events  []*Event
+	warned  bool
+	pending chan struct{} // signalled when events are pushed
+}
+
+// newEventQueue creates an empty event queue
+func newEventQueue() *eventQueue {
+	return &eventQueue{pending: make(chan struct{}, 1)}
+}
+
+// Push adds an event to the end of the queue without waiting
+func (q *eventQueue) Push(ev *Event) {
+	q.mu.Lock()
+	q.events = append(q.events, ev)
+	if len(q.events) > eventBacklogWarning && !q.warned {
+		q.warned = true
+		log.Printf("⚠️  %d events are waiting to be processed", len(q.events))
+	}
+	q.mu.Unlock()
+
+	select {
+	case q.pending <- struct{}{}:
+	default:
+	}
+}
+
+// Pop waits for the oldest event and removes it from the queue. It returns
+// nil once the context is cancelled.
+func (q *eventQueue) Pop(ctx context.Context) *Event {
+	for {
+		q.mu.Lock()
+		if len(q.events) > 0 {
+			ev := q.events[0]
+			q.events[0] = nil
+			q.events = q.events[1:]
+			if len(q.events) == 0 {
+				q.warned = false
+			}
+			q.mu.Unlock()
+			return ev
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-q.pending:
+		}
+	}
+}
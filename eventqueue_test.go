@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEventQueueKeepsOrderWithoutBlocking(t *testing.T) {
+	q := newEventQueue()
+
+	// Pushing well past the warning size must not wait for a reader
+	const n = eventBacklogWarning * 4
+	done := make(chan struct{})
+	go func() {
+		for i := 1; i <= n; i++ {
+			q.Push(&Event{StoredID: int64(i)})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Push blocked without a reader")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := 1; i <= n; i++ {
+		if ev := q.Pop(ctx); ev == nil || ev.StoredID != int64(i) {
+			t.Fatalf("event %d = %+v, want StoredID %d", i, ev, i)
+		}
+	}
+
+	cancel()
+	if ev := q.Pop(ctx); ev != nil {
+		t.Errorf("Pop after cancel = %+v, want nil", ev)
+	}
+}
@@ -0,0 +1,129 @@
+package fakejoystick
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// GatewayIdentifier is the ActionCable identifier of the GatewayChannel
+const GatewayIdentifier = `{"channel":"GatewayChannel"}`
+
+// Frame is a single ActionCable frame sent to the bot
+type Frame map[string]interface{}
+
+// Author describes the user attached to chat messages and stream events
+type Author struct {
+	Slug                 string `json:"slug"`
+	Username             string `json:"username"`
+	DisplayNameWithFlair string `json:"displayNameWithFlair,omitempty"`
+	SignedPhotoThumbURL  string `json:"signedPhotoThumbUrl,omitempty"`
+	IsSubscriber         bool   `json:"isSubscriber"`
+}
+
+// nextID numbers generated messages so every frame has a unique id
+var nextID atomic.Int64
+
+func newID() string {
+	return fmt.Sprintf("fake-%d", nextID.Add(1))
+}
+
+// Welcome returns the frame ActionCable sends right after connecting
+func Welcome() Frame {
+	return Frame{"type": "welcome"}
+}
+
+// ConfirmSubscription returns the frame acknowledging a GatewayChannel subscription
+func ConfirmSubscription() Frame {
+	return Frame{"type": "confirm_subscription", "identifier": GatewayIdentifier}
+}
+
+// RejectSubscription returns the frame refusing a GatewayChannel subscription
+func RejectSubscription() Frame {
+	return Frame{"type": "reject_subscription", "identifier": GatewayIdentifier}
+}
+
+// Ping returns a heartbeat frame for the given time
+func Ping(t time.Time) Frame {
+	return Frame{"type": "ping", "message": t.Unix()}
+}
+
+// Message wraps a channel message into a GatewayChannel frame
+func Message(message map[string]interface{}) Frame {
+	return Frame{"identifier": GatewayIdentifier, "message": message}
+}
+
+// StreamEvent returns a StreamEvent frame. The metadata is JSON encoded into a
+// string the way the real gateway sends it.
+func StreamEvent(eventType, text string, metadata map[string]interface{}, author *Author) Frame {
+	encoded, _ := json.Marshal(metadata)
+	message := map[string]interface{}{
+		"id":        newID(),
+		"event":     "StreamEvent",
+		"type":      eventType,
+		"text":      text,
+		"metadata":  string(encoded),
+		"createdAt": time.Now().UTC().Format(time.RFC3339),
+		"channelId": "fake-channel",
+	}
+	if author != nil {
+		message["author"] = author
+	}
+	return Message(message)
+}
+
+// Tipped returns a tipped StreamEvent frame
+func Tipped(who string, amount int, tipMenuItem, text string) Frame {
+	return StreamEvent("tipped", text, map[string]interface{}{
+		"who":           who,
+		"what":          "Tipped",
+		"how_much":      amount,
+		"tip_menu_item": tipMenuItem,
+	}, nil)
+}
+
+// Followed returns a followed StreamEvent frame
+func Followed(who string) Frame {
+	return StreamEvent("followed", who+" followed", map[string]interface{}{
+		"who":  who,
+		"what": "Followed",
+	}, nil)
+}
+
+// Subscribed returns a subscribed StreamEvent frame
+func Subscribed(who string) Frame {
+	return StreamEvent("subscribed", who+" subscribed", map[string]interface{}{
+		"who":  who,
+		"what": "Subscribed",
+	}, nil)
+}
+
+// ChatMessage returns a ChatMessage frame
+func ChatMessage(author Author, text string) Frame {
+	id := newID()
+	return Message(map[string]interface{}{
+		"id":         id,
+		"event":      "ChatMessage",
+		"messageId":  id,
+		"text":       text,
+		"createdAt":  time.Now().UTC().Format(time.RFC3339),
+		"channelId":  "fake-channel",
+		"visibility": "public",
+		"mention":    false,
+		"emotesUsed": []interface{}{},
+		"author":     author,
+	})
+}
+
+// UserPresence returns a UserPresence frame; presenceType is enter_stream or leave_stream
+func UserPresence(presenceType, username string) Frame {
+	return Message(map[string]interface{}{
+		"id":        newID(),
+		"event":     "UserPresence",
+		"type":      presenceType,
+		"text":      username,
+		"createdAt": time.Now().UTC().Format(time.RFC3339),
+		"channelId": "fake-channel",
+	})
+}
@@ -0,0 +1,390 @@
+// Package fakejoystick implements a stand-in for the Joystick TV API: the OAuth
+// authorize and token endpoints and the ActionCable gateway WebSocket. It is
+// meant for end-to-end tests and demos of the bot without a live stream.
+//
+// Point the bot at it with JOYSTICK_BASE_URL, or wrap it in httptest.NewServer
+// and pass the URL to JoystickClient.SetEndpoints.
+package fakejoystick
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Step is a frame of the scripted feed, sent after waiting Delay
+type Step struct {
+	Delay time.Duration
+	Frame Frame
+}
+
+// TokenRequest records a call to the token endpoint
+type TokenRequest struct {
+	GrantType string
+	Form      url.Values
+	Status    int
+}
+
+// Server is a fake Joystick TV server. Configure the exported fields before
+// serving; they are not safe to change while connections are open.
+type Server struct {
+	ClientID     string
+	ClientSecret string
+
+	// ExpiresIn is the lifetime in seconds of issued access tokens
+	ExpiresIn int
+	// PingInterval is how often pings are sent on the gateway; 0 disables pings
+	PingInterval time.Duration
+	// RejectSubscriptions answers subscribe commands with reject_subscription
+	RejectSubscriptions bool
+	// Script is played to every connection once its subscription is confirmed
+	Script []Step
+
+	mux *http.ServeMux
+
+	mu            sync.Mutex
+	codes         map[string]string // authorization code -> redirect URI
+	refreshTokens map[string]bool
+	accessTokens  map[string]bool
+	tokenRequests []TokenRequest
+	conns         map[*conn]struct{}
+	connects      int
+}
+
+// conn is a subscribed gateway connection
+type conn struct {
+	ws      *websocket.Conn
+	writeMu sync.Mutex
+	done    chan struct{}
+	once    sync.Once
+}
+
+// New creates a fake server accepting the given client credentials
+func New(clientID, clientSecret string) *Server {
+	fs := &Server{
+		ClientID:      clientID,
+		ClientSecret:  clientSecret,
+		ExpiresIn:     3600,
+		PingInterval:  3 * time.Second,
+		codes:         make(map[string]string),
+		refreshTokens: make(map[string]bool),
+		accessTokens:  make(map[string]bool),
+		conns:         make(map[*conn]struct{}),
+	}
+
+	fs.mux = http.NewServeMux()
+	fs.mux.HandleFunc("/api/oauth/authorize", fs.handleAuthorize)
+	fs.mux.HandleFunc("/api/oauth/token", fs.handleToken)
+	fs.mux.HandleFunc("/cable", fs.handleCable)
+	return fs
+}
+
+// ServeHTTP implements http.Handler
+func (fs *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fs.mux.ServeHTTP(w, r)
+}
+
+// Handle registers an additional handler on the fake server's mux
+func (fs *Server) Handle(pattern string, handler http.Handler) {
+	fs.mux.Handle(pattern, handler)
+}
+
+// randomToken returns a random hex string
+func randomToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// basicToken returns the base64 "client id:client secret" pair the bot authenticates with
+func (fs *Server) basicToken() string {
+	return base64.StdEncoding.EncodeToString([]byte(fs.ClientID + ":" + fs.ClientSecret))
+}
+
+// handleAuthorize approves every authorization request for the configured client
+// and redirects back to the bot with a fresh code
+func (fs *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != fs.ClientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	if q.Get("response_type") != "code" {
+		http.Error(w, "unsupported response_type", http.StatusBadRequest)
+		return
+	}
+
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirect.Scheme == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	code := randomToken()
+	fs.mu.Lock()
+	fs.codes[code] = q.Get("redirect_uri")
+	fs.mu.Unlock()
+
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// handleToken implements the authorization_code and refresh_token grants
+func (fs *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	status := fs.issueToken(w, r)
+
+	fs.mu.Lock()
+	fs.tokenRequests = append(fs.tokenRequests, TokenRequest{
+		GrantType: r.PostForm.Get("grant_type"),
+		Form:      r.PostForm,
+		Status:    status,
+	})
+	fs.mu.Unlock()
+}
+
+// issueToken validates a token request, writes the response and returns its status
+func (fs *Server) issueToken(w http.ResponseWriter, r *http.Request) int {
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != fs.ClientID || secret != fs.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return http.StatusUnauthorized
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code := r.PostForm.Get("code")
+		redirectURI, ok := fs.codes[code]
+		if !ok || redirectURI != r.PostForm.Get("redirect_uri") {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return http.StatusBadRequest
+		}
+		delete(fs.codes, code)
+	case "refresh_token":
+		refreshToken := r.PostForm.Get("refresh_token")
+		if !fs.refreshTokens[refreshToken] {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return http.StatusBadRequest
+		}
+		delete(fs.refreshTokens, refreshToken)
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return http.StatusBadRequest
+	}
+
+	accessToken := randomToken()
+	refreshToken := randomToken()
+	fs.accessTokens[accessToken] = true
+	fs.refreshTokens[refreshToken] = true
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    fs.ExpiresIn,
+		"token_type":    "Bearer",
+	})
+	return http.StatusOK
+}
+
+// TokenRequests returns the token endpoint calls received so far
+func (fs *Server) TokenRequests() []TokenRequest {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return append([]TokenRequest(nil), fs.tokenRequests...)
+}
+
+// upgrader accepts gateway connections speaking the ActionCable JSON protocol
+var upgrader = websocket.Upgrader{
+	Subprotocols: []string{"actioncable-v1-json"},
+	CheckOrigin:  func(r *http.Request) bool { return true },
+}
+
+// handleCable serves the ActionCable gateway
+func (fs *Server) handleCable(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("token") != fs.basicToken() {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("fakejoystick: upgrade failed: %v", err)
+		return
+	}
+
+	c := &conn{ws: ws, done: make(chan struct{})}
+	defer c.close()
+
+	fs.mu.Lock()
+	fs.connects++
+	fs.mu.Unlock()
+
+	if err := c.send(Welcome()); err != nil {
+		return
+	}
+
+	// Wait for the subscribe command before sending anything else
+	for {
+		var cmd struct {
+			Command    string `json:"command"`
+			Identifier string `json:"identifier"`
+		}
+		if err := ws.ReadJSON(&cmd); err != nil {
+			return
+		}
+		if cmd.Command != "subscribe" || cmd.Identifier != GatewayIdentifier {
+			continue
+		}
+		if fs.RejectSubscriptions {
+			c.send(RejectSubscription())
+			continue
+		}
+		if err := c.send(ConfirmSubscription()); err != nil {
+			return
+		}
+		break
+	}
+
+	fs.mu.Lock()
+	fs.conns[c] = struct{}{}
+	fs.mu.Unlock()
+	defer func() {
+		fs.mu.Lock()
+		delete(fs.conns, c)
+		fs.mu.Unlock()
+	}()
+
+	go fs.play(c)
+	if fs.PingInterval > 0 {
+		go fs.ping(c)
+	}
+
+	// Drain client frames until the connection closes
+	for {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// play sends the script to a connection
+func (fs *Server) play(c *conn) {
+	for _, step := range fs.Script {
+		if step.Delay > 0 {
+			select {
+			case <-c.done:
+				return
+			case <-time.After(step.Delay):
+			}
+		}
+		if err := c.send(step.Frame); err != nil {
+			return
+		}
+	}
+}
+
+// ping sends heartbeats to a connection until it closes
+func (fs *Server) ping(c *conn) {
+	ticker := time.NewTicker(fs.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case t := <-ticker.C:
+			if err := c.send(Ping(t)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Send broadcasts frames to every subscribed connection
+func (fs *Server) Send(frames ...Frame) {
+	for _, c := range fs.subscribed() {
+		for _, f := range frames {
+			if err := c.send(f); err != nil {
+				break
+			}
+		}
+	}
+}
+
+// DropConnections closes every gateway connection, simulating a network failure
+func (fs *Server) DropConnections() {
+	for _, c := range fs.subscribed() {
+		c.close()
+	}
+}
+
+// Subscribers returns the number of connections subscribed to the GatewayChannel
+func (fs *Server) Subscribers() int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return len(fs.conns)
+}
+
+// Connects returns how many gateway connections were accepted so far
+func (fs *Server) Connects() int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.connects
+}
+
+// subscribed returns a snapshot of the subscribed connections
+func (fs *Server) subscribed() []*conn {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	conns := make([]*conn, 0, len(fs.conns))
+	for c := range fs.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// send writes a frame, serializing writes from the script, pings and broadcasts
+func (c *conn) send(f Frame) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.ws.WriteJSON(f)
+}
+
+// close closes the connection once
+func (c *conn) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.ws.Close()
+	})
+}
+
+// writeJSON writes a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
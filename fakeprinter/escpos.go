@@ -0,0 +1,548 @@
+package fakeprinter
+
+import (
+	"image"
+	"image/color"
+	"strings"
+)
+
+// ESC/POS control bytes
+const (
+	lf  = 0x0A
+	cr  = 0x0D
+	dle = 0x10
+	esc = 0x1B
+	gs  = 0x1D
+)
+
+// Alignment of a printed line
+type Alignment int
+
+const (
+	AlignLeft Alignment = iota
+	AlignCenter
+	AlignRight
+)
+
+// Line is a printed line of text with the style active when it started
+type Line struct {
+	Text      string
+	Align     Alignment
+	Bold      bool
+	Underline bool
+	Width     int // character width multiplier, 1-8
+	Height    int // character height multiplier, 1-8
+}
+
+// Image is a printed raster image with the justification active when it was printed
+type Image struct {
+	image.Image
+	Align Alignment
+}
+
+// Receipt is everything printed between two cuts
+type Receipt struct {
+	// Items holds the printed lines and images in paper order; each entry is
+	// either a Line or an Image
+	Items []interface{}
+	Cut   bool
+	Raw   []byte
+}
+
+// Lines returns the printed text lines
+func (r *Receipt) Lines() []Line {
+	var lines []Line
+	for _, item := range r.Items {
+		if line, ok := item.(Line); ok {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Images returns the printed raster images
+func (r *Receipt) Images() []image.Image {
+	var images []image.Image
+	for _, item := range r.Items {
+		if img, ok := item.(image.Image); ok {
+			images = append(images, img)
+		}
+	}
+	return images
+}
+
+// Text returns the printed text, one line per row
+func (r *Receipt) Text() string {
+	var b strings.Builder
+	for _, line := range r.Lines() {
+		b.WriteString(line.Text)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// Contains reports whether any printed line contains s
+func (r *Receipt) Contains(s string) bool {
+	for _, line := range r.Lines() {
+		if strings.Contains(line.Text, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parser decodes an ESC/POS byte stream into receipts. It accepts the stream
+// in arbitrary chunks and keeps incomplete commands until more data arrives.
+type Parser struct {
+	// Status is reported to DLE EOT status requests
+	Status Status
+
+	buf      []byte
+	current  Receipt
+	receipts []Receipt
+
+	text      []byte
+	align     Alignment
+	bold      bool
+	underline bool
+	width     int
+	height    int
+
+	// column bit images (ESC *) are collected until the next non-image data
+	stripes []*image.Gray
+	// graphics stored with GS ( L function 112, printed by function 50
+	stored *image.Gray
+}
+
+// NewParser creates a parser in the printer's initial state
+func NewParser() *Parser {
+	p := &Parser{}
+	p.reset()
+	return p
+}
+
+// reset restores the initial print mode (ESC @)
+func (p *Parser) reset() {
+	p.align = AlignLeft
+	p.bold = false
+	p.underline = false
+	p.width = 1
+	p.height = 1
+}
+
+// Write feeds bytes to the parser and returns the bytes the printer answers
+// with, such as status responses
+func (p *Parser) Write(data []byte) []byte {
+	p.buf = append(p.buf, data...)
+	p.current.Raw = append(p.current.Raw, data...)
+
+	var response []byte
+	for len(p.buf) > 0 {
+		n, resp, ok := p.step(p.buf)
+		if !ok {
+			break // incomplete command, wait for more data
+		}
+		response = append(response, resp...)
+		p.buf = p.buf[n:]
+	}
+	return response
+}
+
+// Flush ends the current receipt as if the paper was cut, for example when
+// the connection closes. Returns false if nothing was printed.
+func (p *Parser) Flush() bool {
+	p.endLine(false)
+	p.flushStripes()
+	if len(p.current.Items) == 0 {
+		p.current = Receipt{}
+		return false
+	}
+	p.receipts = append(p.receipts, p.current)
+	p.current = Receipt{}
+	return true
+}
+
+// Receipts returns the receipts completed so far
+func (p *Parser) Receipts() []Receipt {
+	return append([]Receipt(nil), p.receipts...)
+}
+
+// step parses one command or character from b. It returns the bytes consumed,
+// the printer's response and false if b holds an incomplete command.
+func (p *Parser) step(b []byte) (int, []byte, bool) {
+	switch b[0] {
+	case lf:
+		p.endLine(true)
+		return 1, nil, true
+	case cr:
+		return 1, nil, true
+	case dle:
+		return p.stepDLE(b)
+	case esc:
+		return p.stepESC(b)
+	case gs:
+		return p.stepGS(b)
+	}
+
+	if b[0] < 0x20 {
+		return 1, nil, true // other control characters are ignored
+	}
+
+	p.flushStripes()
+	p.text = append(p.text, b[0])
+	return 1, nil, true
+}
+
+// stepDLE handles real-time commands (DLE EOT status requests)
+func (p *Parser) stepDLE(b []byte) (int, []byte, bool) {
+	if len(b) < 3 {
+		return 0, nil, false
+	}
+	if b[1] == 0x04 && !p.Status.NoAnswer {
+		return 3, []byte{p.Status.response(b[2])}, true
+	}
+	return 3, nil, true
+}
+
+// Status is the printer state reported to DLE EOT status requests
+type Status struct {
+	Offline      bool
+	CoverOpen    bool
+	PaperNearEnd bool
+	PaperOut     bool
+	// NoAnswer ignores status requests, like printers without DLE EOT support
+	NoAnswer bool
+}
+
+// response returns the status byte for DLE EOT n. Bits 1 and 4 are always set.
+func (st Status) response(n byte) byte {
+	resp := byte(0x12)
+	switch n {
+	case 1: // printer status
+		if st.Offline || st.CoverOpen || st.PaperOut {
+			resp |= 0x08
+		}
+	case 2: // offline cause
+		if st.CoverOpen {
+			resp |= 0x04
+		}
+		if st.PaperOut {
+			resp |= 0x20
+		}
+	case 4: // roll paper sensor
+		if st.PaperNearEnd {
+			resp |= 0x0C
+		}
+		if st.PaperOut {
+			resp |= 0x60
+		}
+	}
+	return resp
+}
+
+// stepESC handles ESC commands
+func (p *Parser) stepESC(b []byte) (int, []byte, bool) {
+	if len(b) < 2 {
+		return 0, nil, false
+	}
+
+	switch b[1] {
+	case '@': // initialize
+		p.reset()
+		return 2, nil, true
+	case '2': // default line spacing
+		return 2, nil, true
+	case 'd': // print and feed n lines
+		if len(b) < 3 {
+			return 0, nil, false
+		}
+		p.endLine(false)
+		for i := 0; i < int(b[2]); i++ {
+			p.endLine(true)
+		}
+		return 3, nil, true
+	case 'a': // justification
+		if len(b) < 3 {
+			return 0, nil, false
+		}
+		p.align = Alignment(b[2] % 48 % 3)
+		return 3, nil, true
+	case 'E': // emphasized
+		if len(b) < 3 {
+			return 0, nil, false
+		}
+		p.bold = b[2]&1 == 1
+		return 3, nil, true
+	case '-': // underline
+		if len(b) < 3 {
+			return 0, nil, false
+		}
+		p.underline = b[2]%48 != 0
+		return 3, nil, true
+	case '!': // print mode
+		if len(b) < 3 {
+			return 0, nil, false
+		}
+		p.bold = b[2]&0x08 != 0
+		p.height = 1
+		if b[2]&0x10 != 0 {
+			p.height = 2
+		}
+		p.width = 1
+		if b[2]&0x20 != 0 {
+			p.width = 2
+		}
+		p.underline = b[2]&0x80 != 0
+		return 3, nil, true
+	case '*': // column bit image
+		if len(b) < 5 {
+			return 0, nil, false
+		}
+		columns := int(b[3]) + int(b[4])*256
+		bytesPerColumn := 1
+		if b[2] == 32 || b[2] == 33 {
+			bytesPerColumn = 3
+		}
+		n := 5 + columns*bytesPerColumn
+		if len(b) < n {
+			return 0, nil, false
+		}
+		p.endLine(false)
+		p.stripes = append(p.stripes, columnImage(b[5:n], columns, bytesPerColumn))
+		return n, nil, true
+	case 'J', 'e', '3', 'M', 't', 'R', 'V', '{', 'G', ' ', 'U': // one parameter byte, no effect on content
+		if len(b) < 3 {
+			return 0, nil, false
+		}
+		return 3, nil, true
+	case 'c': // panel and sensor settings: ESC c n1 n2
+		if len(b) < 4 {
+			return 0, nil, false
+		}
+		return 4, nil, true
+	case 'p': // pulse drawer
+		if len(b) < 5 {
+			return 0, nil, false
+		}
+		return 5, nil, true
+	}
+
+	return 2, nil, true
+}
+
+// stepGS handles GS commands
+func (p *Parser) stepGS(b []byte) (int, []byte, bool) {
+	if len(b) < 2 {
+		return 0, nil, false
+	}
+
+	switch b[1] {
+	case '!': // character size
+		if len(b) < 3 {
+			return 0, nil, false
+		}
+		p.width = int(b[2]>>4) + 1
+		p.height = int(b[2]&0x0F) + 1
+		return 3, nil, true
+	case 'V': // cut
+		if len(b) < 3 {
+			return 0, nil, false
+		}
+		n := 3
+		if b[2] == 65 || b[2] == 66 || b[2] == 97 || b[2] == 98 || b[2] == 103 || b[2] == 104 {
+			if len(b) < 4 {
+				return 0, nil, false
+			}
+			n = 4
+		}
+		p.current.Raw = p.current.Raw[:len(p.current.Raw)-len(p.buf)+n]
+		p.current.Cut = true
+		rest := append([]byte(nil), p.buf[n:]...)
+		p.Flush()
+		p.current.Raw = rest
+		return n, nil, true
+	case 'v': // raster bit image: GS v 0 m xL xH yL yH data
+		if len(b) < 8 {
+			return 0, nil, false
+		}
+		widthBytes := int(b[4]) + int(b[5])*256
+		height := int(b[6]) + int(b[7])*256
+		n := 8 + widthBytes*height
+		if len(b) < n {
+			return 0, nil, false
+		}
+		p.endLine(false)
+		p.flushStripes()
+		p.current.Items = append(p.current.Items, Image{Image: rasterImage(b[8:n], widthBytes, height), Align: p.align})
+		return n, nil, true
+	case '(': // GS ( L graphics: GS ( L pL pH m fn ...
+		if len(b) < 5 {
+			return 0, nil, false
+		}
+		n := 5 + int(b[3]) + int(b[4])*256
+		if len(b) < n {
+			return 0, nil, false
+		}
+		if b[2] == 'L' {
+			p.graphics(b[5:n])
+		}
+		return n, nil, true
+	case 'B', 'H', 'h', 'w', 'f', 'b', 'a', 'r', 'I': // one parameter byte
+		if len(b) < 3 {
+			return 0, nil, false
+		}
+		return 3, nil, true
+	case 'L', 'W': // two parameter bytes
+		if len(b) < 4 {
+			return 0, nil, false
+		}
+		return 4, nil, true
+	case 'k': // barcode, format B: GS k m n data
+		if len(b) < 3 {
+			return 0, nil, false
+		}
+		if b[2] <= 6 {
+			// format A: NUL terminated
+			for i := 3; i < len(b); i++ {
+				if b[i] == 0 {
+					return i + 1, nil, true
+				}
+			}
+			return 0, nil, false
+		}
+		if len(b) < 4 || len(b) < 4+int(b[3]) {
+			return 0, nil, false
+		}
+		return 4 + int(b[3]), nil, true
+	}
+
+	return 2, nil, true
+}
+
+// graphics handles GS ( L functions 112 (store raster) and 50 (print stored)
+func (p *Parser) graphics(params []byte) {
+	if len(params) < 2 {
+		return
+	}
+	switch params[1] {
+	case 112: // m fn a bx by c xL xH yL yH data
+		if len(params) < 10 {
+			return
+		}
+		width := int(params[6]) + int(params[7])*256
+		height := int(params[8]) + int(params[9])*256
+		widthBytes := (width + 7) / 8
+		if len(params) < 10+widthBytes*height {
+			return
+		}
+		p.stored = rasterImage(params[10:10+widthBytes*height], widthBytes, height)
+	case 50, 2:
+		if p.stored != nil {
+			p.endLine(false)
+			p.flushStripes()
+			p.current.Items = append(p.current.Items, Image{Image: p.stored, Align: p.align})
+			p.stored = nil
+		}
+	}
+}
+
+// endLine finishes the current text line. With force, an empty line is
+// recorded as well (a line feed on an empty buffer feeds blank paper).
+func (p *Parser) endLine(force bool) {
+	if len(p.text) == 0 && !force {
+		return
+	}
+	if len(p.text) == 0 && len(p.stripes) > 0 {
+		// Line feeds terminate bit image stripes rather than printing blank lines
+		return
+	}
+
+	p.current.Items = append(p.current.Items, Line{
+		Text:      decodeText(p.text),
+		Align:     p.align,
+		Bold:      p.bold,
+		Underline: p.underline,
+		Width:     p.width,
+		Height:    p.height,
+	})
+	p.text = p.text[:0]
+}
+
+// flushStripes joins collected bit image stripes into one image
+func (p *Parser) flushStripes() {
+	if len(p.stripes) == 0 {
+		return
+	}
+
+	width, height := 0, 0
+	for _, s := range p.stripes {
+		if s.Bounds().Dx() > width {
+			width = s.Bounds().Dx()
+		}
+		height += s.Bounds().Dy()
+	}
+
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	y := 0
+	for _, s := range p.stripes {
+		for sy := 0; sy < s.Bounds().Dy(); sy++ {
+			for sx := 0; sx < s.Bounds().Dx(); sx++ {
+				img.SetGray(sx, y+sy, s.GrayAt(sx, sy))
+			}
+		}
+		y += s.Bounds().Dy()
+	}
+
+	p.current.Items = append(p.current.Items, Image{Image: img, Align: p.align})
+	p.stripes = nil
+}
+
+// rasterImage decodes row-major 1 bit per pixel data (MSB first, 1 = black)
+func rasterImage(data []byte, widthBytes, height int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, widthBytes*8, height))
+	for y := 0; y < height; y++ {
+		for xb := 0; xb < widthBytes; xb++ {
+			v := data[y*widthBytes+xb]
+			for bit := 0; bit < 8; bit++ {
+				c := color.Gray{Y: 0xFF}
+				if v&(0x80>>bit) != 0 {
+					c = color.Gray{Y: 0}
+				}
+				img.SetGray(xb*8+bit, y, c)
+			}
+		}
+	}
+	return img
+}
+
+// columnImage decodes column-major bit image data (ESC *)
+func columnImage(data []byte, columns, bytesPerColumn int) *image.Gray {
+	height := bytesPerColumn * 8
+	img := image.NewGray(image.Rect(0, 0, columns, height))
+	for x := 0; x < columns; x++ {
+		for yb := 0; yb < bytesPerColumn; yb++ {
+			v := data[x*bytesPerColumn+yb]
+			for bit := 0; bit < 8; bit++ {
+				c := color.Gray{Y: 0xFF}
+				if v&(0x80>>bit) != 0 {
+					c = color.Gray{Y: 0}
+				}
+				img.SetGray(x, yb*8+bit, c)
+			}
+		}
+	}
+	return img
+}
+
+// decodeText converts printer text bytes to a string, treating bytes above
+// 0x7F as Latin-1
+func decodeText(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
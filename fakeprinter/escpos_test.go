@@ -0,0 +1,86 @@
+package fakeprinter
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStatusResponses(t *testing.T) {
+	tests := []struct {
+		name   string
+		status Status
+		want   [3]byte // answers to DLE EOT 1, 2 and 4
+	}{
+		{"ready", Status{}, [3]byte{0x12, 0x12, 0x12}},
+		{"cover open", Status{CoverOpen: true}, [3]byte{0x1A, 0x16, 0x12}},
+		{"paper out", Status{PaperOut: true}, [3]byte{0x1A, 0x32, 0x72}},
+		{"paper near end", Status{PaperNearEnd: true}, [3]byte{0x12, 0x12, 0x1E}},
+		{"offline", Status{Offline: true}, [3]byte{0x1A, 0x12, 0x12}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewParser()
+			p.Status = tt.status
+			got := p.Write([]byte{0x10, 0x04, 1, 0x10, 0x04, 2, 0x10, 0x04, 4})
+			if string(got) != string(tt.want[:]) {
+				t.Errorf("answers = % X, want % X", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusNoAnswer(t *testing.T) {
+	p := NewParser()
+	p.Status = Status{NoAnswer: true, PaperOut: true}
+	if got := p.Write([]byte{0x10, 0x04, 1}); len(got) != 0 {
+		t.Errorf("answers = % X, want none", got)
+	}
+}
+
+func TestServerAnswersStatus(t *testing.T) {
+	fp, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer fp.Close()
+	fp.SetStatus(Status{PaperOut: true})
+
+	conn, err := net.Dial("tcp", fp.Addr())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte{0x10, 0x04, 4}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	answer := make([]byte, 1)
+	if _, err := conn.Read(answer); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if answer[0]&0x60 != 0x60 {
+		t.Errorf("paper sensor answer = %#x, want the paper out bits set", answer[0])
+	}
+}
+
+func TestReceiptTextAndCut(t *testing.T) {
+	p := NewParser()
+	p.Write([]byte("\x1b@\x1ba\x01\x1bE\x01New Tip\n\x1bE\x00alice\n"))
+	p.Write([]byte{0x1D, 'V', 66, 0})
+
+	receipts := p.Receipts()
+	if len(receipts) != 1 {
+		t.Fatalf("got %d receipts, want 1", len(receipts))
+	}
+	r := receipts[0]
+	if !r.Cut {
+		t.Error("receipt was not cut")
+	}
+	lines := r.Lines()
+	if len(lines) != 2 || lines[0].Text != "New Tip" || !lines[0].Bold || lines[0].Align != AlignCenter || lines[1].Text != "alice" || lines[1].Bold {
+		t.Errorf("lines = %+v, want a bold centered header and the username", lines)
+	}
+}
@@ -0,0 +1,193 @@
+package fakeprinter
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Paper geometry in printer dots (203 dpi)
+const (
+	// PaperWidth is the printable width of 80 mm roll paper
+	PaperWidth = 576
+
+	paperMargin = 32 // unprintable paper around the printable area
+	charWidth   = 12 // font A cell
+	charHeight  = 24
+	lineSpacing = 30 // default line spacing (ESC 2)
+	cutGap      = 48 // paper between receipts, with the cut in the middle
+)
+
+var (
+	facesOnce sync.Once
+	faces     [2]font.Face // regular, bold
+	facesErr  error
+)
+
+// loadFaces parses the monospaced fonts used to draw text once
+func loadFaces() error {
+	facesOnce.Do(func() {
+		for i, ttf := range [][]byte{gomono.TTF, gomonobold.TTF} {
+			f, err := opentype.Parse(ttf)
+			if err != nil {
+				facesErr = fmt.Errorf("failed to parse font: %w", err)
+				return
+			}
+			// Go Mono advances 0.6 em, so 20 px gives the 12 dot font A cell
+			faces[i], err = opentype.NewFace(f, &opentype.FaceOptions{
+				Size:    20,
+				DPI:     72,
+				Hinting: font.HintingFull,
+			})
+			if err != nil {
+				facesErr = fmt.Errorf("failed to create font face: %w", err)
+				return
+			}
+		}
+	})
+	return facesErr
+}
+
+// Render draws receipts as one continuous paper roll, marking each cut with a
+// dashed line. Text uses the printer's 12x24 dot cell, so line wrapping and
+// alignment match the paper.
+func Render(receipts ...Receipt) (*image.Gray, error) {
+	if err := loadFaces(); err != nil {
+		return nil, err
+	}
+
+	var rows []*image.Gray
+	for _, r := range receipts {
+		for _, item := range r.Items {
+			switch v := item.(type) {
+			case Line:
+				rows = append(rows, renderLine(v)...)
+			case Image:
+				rows = append(rows, renderImage(v.Image, v.Align))
+			case image.Image:
+				rows = append(rows, renderImage(v, AlignLeft))
+			}
+		}
+		rows = append(rows, renderCut(r.Cut))
+	}
+
+	height := 2 * paperMargin
+	for _, row := range rows {
+		height += row.Bounds().Dy()
+	}
+
+	paper := image.NewGray(image.Rect(0, 0, PaperWidth+2*paperMargin, height))
+	draw.Draw(paper, paper.Bounds(), image.White, image.Point{}, draw.Src)
+
+	y := paperMargin
+	for _, row := range rows {
+		dst := image.Rect(paperMargin, y, paperMargin+PaperWidth, y+row.Bounds().Dy())
+		if row.Bounds().Dx() > PaperWidth {
+			// Cut marks span the whole paper, margins included
+			dst = image.Rect(0, y, paper.Bounds().Dx(), y+row.Bounds().Dy())
+		}
+		draw.Draw(paper, dst, row, image.Point{}, draw.Src)
+		y += row.Bounds().Dy()
+	}
+	return paper, nil
+}
+
+// blankRow returns a white strip of paper
+func blankRow(width, height int) *image.Gray {
+	row := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range row.Pix {
+		row.Pix[i] = 0xFF
+	}
+	return row
+}
+
+// renderLine draws a text line, wrapping it at the paper width like the printer does
+func renderLine(line Line) []*image.Gray {
+	width, height := max(line.Width, 1), max(line.Height, 1)
+	rowHeight := charHeight*height + lineSpacing - charHeight
+
+	runes := []rune(line.Text)
+	if len(runes) == 0 {
+		return []*image.Gray{blankRow(PaperWidth, rowHeight)}
+	}
+
+	face := faces[0]
+	if line.Bold {
+		face = faces[1]
+	}
+
+	perRow := max(PaperWidth/(charWidth*width), 1)
+	var rows []*image.Gray
+	for len(runes) > 0 {
+		n := min(len(runes), perRow)
+		chunk := runes[:n]
+		runes = runes[n:]
+
+		// Draw at the base cell size, then scale up for double width/height
+		cell := blankRow(len(chunk)*charWidth, charHeight)
+		d := font.Drawer{
+			Dst:  cell,
+			Src:  image.Black,
+			Face: face,
+			Dot:  fixed.P(0, charHeight-face.Metrics().Descent.Ceil()),
+		}
+		d.DrawString(string(chunk))
+		if line.Underline {
+			draw.Draw(cell, image.Rect(0, charHeight-2, cell.Bounds().Dx(), charHeight), image.Black, image.Point{}, draw.Src)
+		}
+
+		row := blankRow(PaperWidth, rowHeight)
+		textWidth := cell.Bounds().Dx() * width
+		x0 := alignOffset(textWidth, line.Align)
+		for y := 0; y < charHeight*height; y++ {
+			for x := 0; x < textWidth; x++ {
+				row.SetGray(x0+x, y, cell.GrayAt(x/width, y/height))
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// renderImage places an image on a strip of paper, cropping what doesn't fit
+func renderImage(img image.Image, align Alignment) *image.Gray {
+	b := img.Bounds()
+	row := blankRow(PaperWidth, b.Dy())
+	x0 := alignOffset(min(b.Dx(), PaperWidth), align)
+	draw.Draw(row, image.Rect(x0, 0, x0+b.Dx(), b.Dy()), img, b.Min, draw.Src)
+	return row
+}
+
+// renderCut returns the gap after a receipt, dashed across if the paper was
+// cut. Without a cut the paper continues, as when a connection closes mid-roll.
+func renderCut(cut bool) *image.Gray {
+	if !cut {
+		return blankRow(PaperWidth+2*paperMargin, 0)
+	}
+	row := blankRow(PaperWidth+2*paperMargin, cutGap)
+	for x := 0; x < row.Bounds().Dx(); x++ {
+		if (x/8)%2 == 0 {
+			row.SetGray(x, cutGap/2, color.Gray{Y: 0x80})
+		}
+	}
+	return row
+}
+
+// alignOffset returns where content of the given width starts on the paper
+func alignOffset(width int, align Alignment) int {
+	switch align {
+	case AlignCenter:
+		return max((PaperWidth-width)/2, 0)
+	case AlignRight:
+		return max(PaperWidth-width, 0)
+	}
+	return 0
+}
@@ -0,0 +1,177 @@
+// Package fakeprinter is a stand-in for a network ESC/POS receipt printer. It
+// accepts the raw byte stream a bot sends to RECEIPT_ADDR, parses the ESC/POS
+// commands and records the printed text and raster images so tests can
+// assert on what would have been printed.
+package fakeprinter
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Server is a fake printer listening on TCP
+type Server struct {
+	listener net.Listener
+
+	mu          sync.Mutex
+	cond        *sync.Cond
+	receipts    []Receipt
+	status      Status
+	connections int
+	open        map[net.Conn]struct{}
+	wg          sync.WaitGroup
+}
+
+// Listen starts a fake printer on addr; use "127.0.0.1:0" for a random port
+func Listen(addr string) (*Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	fp := &Server{listener: l, open: make(map[net.Conn]struct{})}
+	fp.cond = sync.NewCond(&fp.mu)
+
+	fp.wg.Add(1)
+	go fp.accept()
+	return fp, nil
+}
+
+// Addr returns the address to use as RECEIPT_ADDR
+func (fp *Server) Addr() string {
+	return fp.listener.Addr().String()
+}
+
+// SetStatus changes the state reported to status requests
+func (fp *Server) SetStatus(st Status) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	fp.status = st
+}
+
+// Receipts returns the receipts printed so far
+func (fp *Server) Receipts() []Receipt {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	return append([]Receipt(nil), fp.receipts...)
+}
+
+// Connections returns how many connections the printer accepted
+func (fp *Server) Connections() int {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	return fp.connections
+}
+
+// WaitForReceipts waits until at least n receipts were printed or the timeout passes
+func (fp *Server) WaitForReceipts(n int, timeout time.Duration) ([]Receipt, error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	if !fp.wait(func() bool { return len(fp.receipts) >= n }, timeout) {
+		return append([]Receipt(nil), fp.receipts...), fmt.Errorf("got %d receipt(s), want %d", len(fp.receipts), n)
+	}
+	return append([]Receipt(nil), fp.receipts...), nil
+}
+
+// wait blocks until done returns true or the timeout passes, reporting which.
+// fp.mu must be held.
+func (fp *Server) wait(done func() bool, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	timer := time.AfterFunc(timeout, func() {
+		fp.mu.Lock()
+		fp.cond.Broadcast()
+		fp.mu.Unlock()
+	})
+	defer timer.Stop()
+
+	for !done() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		fp.cond.Wait()
+	}
+	return true
+}
+
+// Close stops accepting connections, closes open ones and waits for them to finish
+func (fp *Server) Close() error {
+	err := fp.listener.Close()
+
+	fp.mu.Lock()
+	for conn := range fp.open {
+		conn.Close()
+	}
+	fp.mu.Unlock()
+
+	fp.wg.Wait()
+	return err
+}
+
+// accept serves connections until the listener is closed
+func (fp *Server) accept() {
+	defer fp.wg.Done()
+	for {
+		conn, err := fp.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		fp.mu.Lock()
+		fp.connections++
+		fp.open[conn] = struct{}{}
+		fp.mu.Unlock()
+
+		fp.wg.Add(1)
+		go fp.serve(conn)
+	}
+}
+
+// serve parses one connection's byte stream, recording receipts at each cut
+// and when the connection closes
+func (fp *Server) serve(conn net.Conn) {
+	defer fp.wg.Done()
+	defer func() {
+		conn.Close()
+		fp.mu.Lock()
+		delete(fp.open, conn)
+		fp.cond.Broadcast()
+		fp.mu.Unlock()
+	}()
+
+	parser := NewParser()
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			fp.mu.Lock()
+			parser.Status = fp.status
+			fp.mu.Unlock()
+
+			if resp := parser.Write(buf[:n]); len(resp) > 0 {
+				conn.Write(resp)
+			}
+			fp.collect(parser)
+		}
+		if err != nil {
+			parser.Flush()
+			fp.collect(parser)
+			return
+		}
+	}
+}
+
+// collect moves completed receipts from a parser to the server
+func (fp *Server) collect(parser *Parser) {
+	done := parser.Receipts()
+	if len(done) == 0 {
+		return
+	}
+	parser.receipts = nil
+
+	fp.mu.Lock()
+	fp.receipts = append(fp.receipts, done...)
+	fp.cond.Broadcast()
+	fp.mu.Unlock()
+}
@@ -1,107 +1,46 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
-	"image"
-	"image/png"
-	"log"
-	"os"
-	"tyr.codes/golib/receipt"
-	"tyr.codes/golib/receipt/template"
+	"fmt"
 )
 
-// HandleFollowedEvent processes a followed stream event and prints a receipt notification
-func (s *Server) HandleFollowedEvent(msg map[string]interface{}) {
-	// Ensure we have a printer address configured
-	if s.printerAddr == "" {
-		log.Printf("ℹ️  No printer address configured, skipping follower notification")
-		return
-	}
-
-	// Extract the message object
-	message, ok := msg["message"].(map[string]interface{})
-	if !ok {
-		return
-	}
-
-	// Extract username from author field
-	var username string
-	var img image.Image
-	if author, ok := message["author"].(map[string]interface{}); ok {
-		if slug, ok := author["slug"].(string); ok && slug != "" {
-			username = slug
-		} else if usr, ok := author["username"].(string); ok && usr != "" {
-			username = usr
-		}
-
-		// Try to get the user's cached profile thumbnail
-		if username != "" && s.thumbCache != nil {
-			if thumbInfo, err := s.thumbCache.GetThumbnailInfo(username); err == nil && thumbInfo != nil && thumbInfo.FileExtension != "" {
-				thumbPath := s.thumbCache.GetFilePath(username, thumbInfo.FileExtension)
-				if file, err := os.Open(thumbPath); err == nil {
-					defer file.Close()
-					if decodedImg, err := png.Decode(file); err == nil {
-						img = decodedImg
-					}
-				}
-			}
-		}
-	}
+// Default receipt templates of the follow handler; the message falls back to
+// "Welcome!" when the event has no text
+const (
+	defaultFollowHeader  = "New Follower"
+	defaultFollowMessage = `{{.Text | default "Welcome!"}}`
+)
 
-	// If we couldn't get username from author, try metadata
-	if username == "" {
-		metadataStr, ok := message["metadata"].(string)
-		if ok && metadataStr != "" {
-			var metadata map[string]interface{}
-			if err := json.Unmarshal([]byte(metadataStr), &metadata); err == nil {
-				if who, ok := metadata["who"].(string); ok && who != "" {
-					username = who
-				}
-			}
-		}
-	}
+// followHandler prints a receipt when someone follows the stream
+type followHandler struct {
+	tmpl *NotificationTemplate
+}
 
-	// Default to Anonymous if we still don't have a username
-	if username == "" {
-		username = "Anonymous"
-	}
+// Name is "follow", the handler printing the "New Follower" receipt
+func (h *followHandler) Name() string { return "follow" }
 
-	// If we don't have a cached thumbnail, decode the embedded joysticktv.png
-	if img == nil {
-		if decodedImg, err := png.Decode(bytes.NewReader(joysticktv)); err == nil {
-			img = decodedImg
-		} else {
-			log.Printf("⚠️  Failed to decode embedded image: %v", err)
-		}
-	}
+// Handles returns the followed event; every follow prints, whatever the event
+// text says
+func (h *followHandler) Handles() []string { return []string{StreamEventKey("followed")} }
 
-	// Connect to printer, print notification, then disconnect
-	printer := receipt.NewPrinter(s.printerAddr)
-	if err := printer.Connect(); err != nil {
-		log.Printf("❌ Failed to connect to printer: %v", err)
-		return
+// Handle processes a followed stream event and queues a receipt notification
+func (h *followHandler) Handle(hc *HandlerContext) error {
+	if !hc.RequirePrinter() {
+		return nil
 	}
-	defer printer.Disconnect()
 
-	// Extract message text, fallback to default
-	messageText := "Welcome!"
-	if text, ok := message["text"].(string); ok && text != "" {
-		messageText = text
-	}
+	username := hc.Username()
 
-	// Create and print the notification
-	notification := &template.StreamerNotification{
-		Header:   "New Follower",
-		Message:  messageText,
-		Image:    img,
-		Username: username,
+	// Create the notification and queue it for printing
+	notification, err := hc.Notification(h.tmpl)
+	if err != nil {
+		return fmt.Errorf("failed to render follower notification: %w", err)
 	}
 
-	if err := notification.Print(printer); err != nil {
-		log.Printf("⚠️  Failed to print follower notification: %v", err)
-		return
+	if err := hc.Print(notification); err != nil {
+		return fmt.Errorf("failed to queue follower notification: %w", err)
 	}
 
-	log.Printf("✓ Follower notification printed for %s", username)
+	hc.Logf("✓ Follower notification queued for %s", username)
+	return nil
 }
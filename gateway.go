@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Gateway event kinds found in the "event" field of a channel message
+const (
+	KindStreamEvent  = "StreamEvent"
+	KindChatMessage  = "ChatMessage"
+	KindUserPresence = "UserPresence"
+)
+
+// GatewayMessage is the ActionCable envelope of a frame received from the gateway.
+// Control frames (welcome, ping, confirm_subscription, reject_subscription) only
+// carry Type; channel messages carry Identifier and Message.
+type GatewayMessage struct {
+	Type       string          `json:"type,omitempty"`
+	Identifier string          `json:"identifier,omitempty"`
+	Message    json.RawMessage `json:"message,omitempty"`
+
+	// Raw is the frame exactly as received, including fields not decoded here
+	Raw json.RawMessage `json:"-"`
+}
+
+// DecodeGatewayMessage decodes the ActionCable envelope of a frame
+func DecodeGatewayMessage(data []byte) (*GatewayMessage, error) {
+	var gm GatewayMessage
+	if err := json.Unmarshal(data, &gm); err != nil {
+		return nil, fmt.Errorf("failed to decode gateway frame: %w", err)
+	}
+	gm.Raw = append(json.RawMessage(nil), data...)
+	return &gm, nil
+}
+
+// FlexString decodes a JSON string or number into a string, for identifiers
+// the gateway doesn't send consistently
+type FlexString string
+
+// UnmarshalJSON accepts strings, numbers and null
+func (f *FlexString) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		*f = ""
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*f = FlexString(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("expected string or number, got %s", string(data))
+	}
+	*f = FlexString(n.String())
+	return nil
+}
+
+// Author is the user who sent a chat message or triggered an event
+type Author struct {
+	Slug                 string `json:"slug"`
+	Username             string `json:"username"`
+	UsernameColor        string `json:"usernameColor"`
+	DisplayNameWithFlair string `json:"displayNameWithFlair"`
+	SignedPhotoURL       string `json:"signedPhotoUrl"`
+	SignedPhotoThumbURL  string `json:"signedPhotoThumbUrl"`
+	IsStreamer           bool   `json:"isStreamer"`
+	IsModerator          bool   `json:"isModerator"`
+	IsSubscriber         bool   `json:"isSubscriber"`
+}
+
+// Name returns the author's slug, falling back to the username
+func (a *Author) Name() string {
+	if a == nil {
+		return ""
+	}
+	if a.Slug != "" {
+		return a.Slug
+	}
+	return a.Username
+}
+
+// DisplayName returns the author's name as shown in chat, falling back to the
+// username
+func (a *Author) DisplayName() string {
+	if a == nil {
+		return ""
+	}
+	if a.DisplayNameWithFlair != "" {
+		return a.DisplayNameWithFlair
+	}
+	return a.Username
+}
+
+// StreamEventMessage is a StreamEvent (tip, follow, subscription, stream start...)
+type StreamEventMessage struct {
+	ID        FlexString `json:"id"`
+	Event     string     `json:"event"`
+	Type      string     `json:"type"`
+	Text      string     `json:"text"`
+	Metadata  string     `json:"metadata"` // JSON encoded, see StreamEventMetadata
+	CreatedAt string     `json:"createdAt"`
+	ChannelID FlexString `json:"channelId"`
+	Author    *Author    `json:"author,omitempty"`
+}
+
+// StreamEventMetadata holds the fields common to every StreamEvent metadata
+// object, plus all metadata fields decoded generically
+type StreamEventMetadata struct {
+	Who    string                 `json:"who"`
+	What   string                 `json:"what"`
+	Fields map[string]interface{} `json:"-"`
+}
+
+// TippedMetadata is the metadata of a tipped StreamEvent
+type TippedMetadata struct {
+	Who         string `json:"who"`
+	What        string `json:"what"`
+	HowMuch     int    `json:"how_much"`
+	TipMenuItem string `json:"tip_menu_item"`
+}
+
+// FollowedMetadata is the metadata of a followed StreamEvent
+type FollowedMetadata struct {
+	Who  string `json:"who"`
+	What string `json:"what"`
+}
+
+// SubscribedMetadata is the metadata of a subscribed StreamEvent
+type SubscribedMetadata struct {
+	Who    string `json:"who"`
+	What   string `json:"what"`
+	Months int    `json:"months"` // only present for resubscriptions
+}
+
+// ChatMessage is a message sent to the stream chat
+type ChatMessage struct {
+	ID                FlexString        `json:"id"`
+	Event             string            `json:"event"`
+	MessageID         FlexString        `json:"messageId"`
+	Text              string            `json:"text"`
+	BotCommand        string            `json:"botCommand"`
+	BotCommandArg     string            `json:"botCommandArg"`
+	CreatedAt         string            `json:"createdAt"`
+	ChannelID         FlexString        `json:"channelId"`
+	Visibility        string            `json:"visibility"`
+	Mention           bool              `json:"mention"`
+	MentionedUsername string            `json:"mentionedUsername"`
+	EmotesUsed        []json.RawMessage `json:"emotesUsed"`
+	Author            *Author           `json:"author,omitempty"`
+	Streamer          *Author           `json:"streamer,omitempty"`
+}
+
+// UserPresence is sent when a user enters or leaves the stream
+type UserPresence struct {
+	ID        FlexString `json:"id"`
+	Event     string     `json:"event"`
+	Type      string     `json:"type"` // enter_stream or leave_stream
+	Text      string     `json:"text"` // username of the user
+	CreatedAt string     `json:"createdAt"`
+	ChannelID FlexString `json:"channelId"`
+}
+
+// Event is a gateway channel message decoded once per frame and passed to the
+// event store, thumbnail cache and handlers. Exactly one of StreamEvent, Chat
+// and Presence is set for known kinds.
+type Event struct {
+	Frame *GatewayMessage
+	Kind  string // StreamEvent, ChatMessage or UserPresence
+	Type  string // StreamEvent subtype or presence type
+
+	StreamEvent *StreamEventMessage
+	Chat        *ChatMessage
+	Presence    *UserPresence
+
+	// Metadata is decoded for every StreamEvent; the typed metadata matching
+	// the event type is set as well
+	Metadata   *StreamEventMetadata
+	Tipped     *TippedMetadata
+	Followed   *FollowedMetadata
+	Subscribed *SubscribedMetadata
+
+	// ReceivedAt is when the bot received the event
+	ReceivedAt time.Time
+	// StoredID is the event's stream_events row, 0 if it wasn't stored
+	StoredID int64
+}
+
+// DecodeEvent decodes the channel message of a gateway frame.
+// Returns nil without error for frames that carry no message (control frames).
+// If only the StreamEvent metadata is malformed, the event is returned along
+// with the error so it can still be stored.
+func DecodeEvent(frame *GatewayMessage) (*Event, error) {
+	if len(frame.Message) == 0 || bytes.Equal(frame.Message, []byte("null")) {
+		return nil, nil
+	}
+
+	var head struct {
+		Event string `json:"event"`
+		Type  string `json:"type"`
+	}
+	if err := json.Unmarshal(frame.Message, &head); err != nil {
+		return nil, fmt.Errorf("failed to decode gateway message: %w", err)
+	}
+
+	ev := &Event{Frame: frame, Kind: head.Event, Type: head.Type, ReceivedAt: time.Now()}
+
+	switch ev.Kind {
+	case KindStreamEvent:
+		ev.StreamEvent = &StreamEventMessage{}
+		if err := json.Unmarshal(frame.Message, ev.StreamEvent); err != nil {
+			return nil, fmt.Errorf("failed to decode StreamEvent: %w", err)
+		}
+		if err := ev.decodeMetadata(); err != nil {
+			return ev, err
+		}
+	case KindChatMessage:
+		ev.Chat = &ChatMessage{}
+		if err := json.Unmarshal(frame.Message, ev.Chat); err != nil {
+			return nil, fmt.Errorf("failed to decode ChatMessage: %w", err)
+		}
+	case KindUserPresence:
+		ev.Presence = &UserPresence{}
+		if err := json.Unmarshal(frame.Message, ev.Presence); err != nil {
+			return nil, fmt.Errorf("failed to decode UserPresence: %w", err)
+		}
+	}
+
+	return ev, nil
+}
+
+// decodeMetadata decodes the JSON encoded metadata of a StreamEvent
+func (ev *Event) decodeMetadata() error {
+	raw := []byte(ev.StreamEvent.Metadata)
+	if len(raw) == 0 {
+		return nil
+	}
+
+	metadata := &StreamEventMetadata{}
+	if err := json.Unmarshal(raw, metadata); err != nil {
+		return fmt.Errorf("failed to parse %s metadata: %w", ev.Type, err)
+	}
+	if err := json.Unmarshal(raw, &metadata.Fields); err != nil {
+		return fmt.Errorf("failed to parse %s metadata: %w", ev.Type, err)
+	}
+	ev.Metadata = metadata
+
+	var typed interface{}
+	switch ev.Type {
+	case "tipped":
+		ev.Tipped = &TippedMetadata{}
+		typed = ev.Tipped
+	case "followed":
+		ev.Followed = &FollowedMetadata{}
+		typed = ev.Followed
+	case "subscribed":
+		ev.Subscribed = &SubscribedMetadata{}
+		typed = ev.Subscribed
+	default:
+		return nil
+	}
+	if err := json.Unmarshal(raw, typed); err != nil {
+		return fmt.Errorf("failed to parse %s metadata: %w", ev.Type, err)
+	}
+	return nil
+}
+
+// Author returns the author of the event, if the message has one
+func (ev *Event) Author() *Author {
+	switch {
+	case ev.StreamEvent != nil:
+		return ev.StreamEvent.Author
+	case ev.Chat != nil:
+		return ev.Chat.Author
+	}
+	return nil
+}
+
+// User returns who performed the event: the author slug or username, the
+// metadata "who" field, or the presence username. Empty if unknown.
+func (ev *Event) User() string {
+	if name := ev.Author().Name(); name != "" {
+		return name
+	}
+	if ev.Metadata != nil && ev.Metadata.Who != "" {
+		return ev.Metadata.Who
+	}
+	if ev.Presence != nil {
+		return ev.Presence.Text
+	}
+	return ""
+}
+
+// Text returns the text of the event message
+func (ev *Event) Text() string {
+	switch {
+	case ev.StreamEvent != nil:
+		return ev.StreamEvent.Text
+	case ev.Chat != nil:
+		return ev.Chat.Text
+	case ev.Presence != nil:
+		return ev.Presence.Text
+	}
+	return ""
+}
+
+// ID returns the gateway identifier of the message, if any
+func (ev *Event) ID() string {
+	switch {
+	case ev.StreamEvent != nil:
+		return string(ev.StreamEvent.ID)
+	case ev.Chat != nil:
+		if ev.Chat.MessageID != "" {
+			return string(ev.Chat.MessageID)
+		}
+		return string(ev.Chat.ID)
+	case ev.Presence != nil:
+		return string(ev.Presence.ID)
+	}
+	return ""
+}
+
+// CreatedAt returns the gateway timestamp of the message, if any
+func (ev *Event) CreatedAt() string {
+	switch {
+	case ev.StreamEvent != nil:
+		return ev.StreamEvent.CreatedAt
+	case ev.Chat != nil:
+		return ev.Chat.CreatedAt
+	case ev.Presence != nil:
+		return ev.Presence.CreatedAt
+	}
+	return ""
+}
+
+// MessageKey identifies a gateway message across redeliveries: the kind and
+// message id, or the kind, type, user and creation time when the message has
+// no id. Empty when the message carries neither.
+func (ev *Event) MessageKey() string {
+	if id := ev.ID(); id != "" {
+		return ev.Kind + ":" + id
+	}
+	if createdAt := ev.CreatedAt(); createdAt != "" {
+		return ev.Kind + ":" + ev.Type + ":" + ev.User() + "@" + createdAt
+	}
+	return ""
+}
+
+// Keys returns the event keys this event matches, most specific first
+func (ev *Event) Keys() []string {
+	keys := make([]string, 0, 3)
+	if ev.Kind == KindStreamEvent && ev.Type != "" {
+		keys = append(keys, StreamEventKey(ev.Type))
+	}
+	if ev.Kind != "" {
+		keys = append(keys, ev.Kind)
+	}
+	return append(keys, EventKeyAll)
+}
+
+// Amount returns the number of tokens tipped, or 0 for other events
+func (ev *Event) Amount() int {
+	if ev.Tipped != nil {
+		return ev.Tipped.HowMuch
+	}
+	return 0
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"os"
+	"sync"
+
+	"tyr.codes/golib/receipt/template"
+)
+
+// Event keys that handlers can subscribe to. Stream events are keyed by their
+// subtype with StreamEventKey, e.g. StreamEventKey("tipped").
+const (
+	EventKeyAll          = "*"
+	EventKeyStreamEvent  = KindStreamEvent
+	EventKeyChatMessage  = KindChatMessage
+	EventKeyUserPresence = KindUserPresence
+)
+
+// errNoPrinter is returned when a handler tries to print without a configured printer
+var errNoPrinter = errors.New("no printer configured")
+
+// Print outcomes recorded on stored stream events
+const (
+	PrintOutcomePrinted = "printed" // a receipt was queued for printing
+	PrintOutcomeSkipped = "skipped" // handlers chose not to print
+	PrintOutcomeFailed  = "failed"  // a handler failed before printing
+)
+
+// printOutcome collects what the handlers of one event did with it
+type printOutcome struct {
+	status  string
+	reason  string
+	stopped bool
+}
+
+// StreamEventKey returns the event key for a StreamEvent subtype such as "tipped"
+func StreamEventKey(eventType string) string {
+	return EventKeyStreamEvent + ":" + eventType
+}
+
+// EventHandler reacts to gateway events of the types it declares
+type EventHandler interface {
+	// Name identifies the handler in logs and for unregistering
+	Name() string
+	// Handles returns the event keys the handler consumes
+	Handles() []string
+	// Handle processes a single event
+	Handle(hc *HandlerContext) error
+}
+
+// HandlerContext gives a handler the decoded event and shared helpers
+type HandlerContext struct {
+	Event   *Event
+	server  *Server
+	printer Printer
+	outcome *printOutcome
+	preview bool
+}
+
+// Logf logs what the handler did with the event. Nothing is logged while
+// rendering a preview, where no receipt is really queued.
+func (hc *HandlerContext) Logf(format string, args ...any) {
+	if hc.preview {
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// Username resolves who performed the event, falling back to "Anonymous"
+func (hc *HandlerContext) Username() string {
+	if user := hc.Event.User(); user != "" {
+		return user
+	}
+	return "Anonymous"
+}
+
+// TemplateData returns the event data for receipt templates
+func (hc *HandlerContext) TemplateData() TemplateData {
+	return newTemplateData(hc.Event, hc.Username())
+}
+
+// Notification renders a receipt template for the event, with the acting
+// user's name and thumbnail
+func (hc *HandlerContext) Notification(tmpl *NotificationTemplate) (*template.StreamerNotification, error) {
+	data := hc.TemplateData()
+	header, message, err := tmpl.Render(data)
+	if err != nil {
+		return nil, err
+	}
+	return &template.StreamerNotification{
+		Header:   header,
+		Message:  message,
+		Image:    hc.Thumbnail(data.Username),
+		Username: data.Username,
+	}, nil
+}
+
+// Thumbnail returns the cached profile image for a user, or the embedded
+// Joystick TV logo when none is cached
+func (hc *HandlerContext) Thumbnail(username string) image.Image {
+	if img := hc.cachedThumbnail(username); img != nil {
+		return img
+	}
+
+	img, err := png.Decode(bytes.NewReader(joysticktv))
+	if err != nil {
+		log.Printf("⚠️  Failed to decode embedded image: %v", err)
+		return nil
+	}
+	return img
+}
+
+// cachedThumbnail loads a user's thumbnail from the thumbnail cache
+func (hc *HandlerContext) cachedThumbnail(username string) image.Image {
+	tc := hc.server.thumbCache
+	if username == "" || tc == nil {
+		return nil
+	}
+
+	thumbInfo, err := tc.GetThumbnailInfo(username)
+	if err != nil || thumbInfo == nil || thumbInfo.FileExtension == "" {
+		return nil
+	}
+
+	file, err := os.Open(tc.GetFilePath(username, thumbInfo.FileExtension))
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	img, err := png.Decode(file)
+	if err != nil {
+		return nil
+	}
+	return img
+}
+
+// RequirePrinter reports whether a printer is configured. Without one it skips
+// the event, so a handler can return right away.
+func (hc *HandlerContext) RequirePrinter() bool {
+	if hc.printer != nil {
+		return true
+	}
+	hc.Logf("ℹ️  No printer address configured, skipping %s event from %s", eventName(hc.Event), hc.Username())
+	hc.Skip("no printer configured")
+	return false
+}
+
+// Print sends a notification to the printer the event is dispatched to
+func (hc *HandlerContext) Print(notification *template.StreamerNotification) error {
+	return hc.PrintReceipt(&Receipt{Notification: notification})
+}
+
+// PrintReceipt sends a receipt with print options to the printer the event is
+// dispatched to. A receipt naming no printer is printed once on every printer
+// the event is routed to. The event counts as printed once any printer queued
+// the receipt; failures on the other printers are logged, and an error is
+// returned only when no printer took it.
+func (hc *HandlerContext) PrintReceipt(r *Receipt) error {
+	if hc.printer == nil {
+		return errNoPrinter
+	}
+
+	targets := []string{r.Printer}
+	if r.Printer == "" && hc.server.printers != nil {
+		targets = hc.server.printers.Route(hc.Event)
+	}
+	if len(targets) == 0 {
+		hc.Skip("no printer is routed for this event")
+		return nil
+	}
+
+	var errs []error
+	printed := false
+	for _, name := range targets {
+		routed := *r
+		routed.Printer = name
+		if err := hc.printer.PrintReceipt(&routed); err != nil {
+			if name != "" {
+				err = fmt.Errorf("printer %s: %w", name, err)
+			}
+			errs = append(errs, err)
+			continue
+		}
+		printed = true
+	}
+
+	if printed {
+		hc.outcome.status = PrintOutcomePrinted
+		hc.outcome.reason = ""
+		for _, err := range errs {
+			hc.Logf("⚠️  Failed to print %s event: %v", eventName(hc.Event), err)
+		}
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+// Stop keeps the remaining handlers from seeing the event
+func (hc *HandlerContext) Stop() {
+	hc.outcome.stopped = true
+}
+
+// Skip records why the handler deliberately didn't print a receipt for the
+// event. It has no effect once another handler printed one.
+func (hc *HandlerContext) Skip(reason string) {
+	if hc.outcome.status == PrintOutcomePrinted {
+		return
+	}
+	hc.outcome.status = PrintOutcomeSkipped
+	hc.outcome.reason = reason
+}
+
+// HandlerRegistry holds the event handlers and dispatches events to them
+type HandlerRegistry struct {
+	mu       sync.RWMutex
+	handlers []EventHandler
+}
+
+// NewHandlerRegistry creates an empty handler registry
+func NewHandlerRegistry() *HandlerRegistry {
+	return &HandlerRegistry{}
+}
+
+// Register adds a handler. Handlers run in registration order.
+func (hr *HandlerRegistry) Register(h EventHandler) error {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+
+	for _, existing := range hr.handlers {
+		if existing.Name() == h.Name() {
+			return fmt.Errorf("handler %s is already registered", h.Name())
+		}
+	}
+	hr.handlers = append(hr.handlers, h)
+	return nil
+}
+
+// Unregister removes the handler with the given name and reports whether it was registered
+func (hr *HandlerRegistry) Unregister(name string) bool {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+
+	for i, h := range hr.handlers {
+		if h.Name() == name {
+			hr.handlers = append(hr.handlers[:i:i], hr.handlers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Handlers returns the registered handlers in registration order
+func (hr *HandlerRegistry) Handlers() []EventHandler {
+	hr.mu.RLock()
+	defer hr.mu.RUnlock()
+	return append([]EventHandler(nil), hr.handlers...)
+}
+
+// handlersFor returns the handlers consuming any of the given event keys
+func (hr *HandlerRegistry) handlersFor(keys []string) []EventHandler {
+	var matched []EventHandler
+	for _, h := range hr.Handlers() {
+		if handlesAny(h, keys) {
+			matched = append(matched, h)
+		}
+	}
+	return matched
+}
+
+// handlesAny reports whether a handler consumes one of the event keys
+func handlesAny(h EventHandler, keys []string) bool {
+	for _, want := range h.Handles() {
+		for _, key := range keys {
+			if want == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Dispatch runs every handler that consumes the event, logging handler errors.
+// Events already dispatched recently are skipped, so redelivered messages
+// don't print twice.
+func (s *Server) Dispatch(ev *Event) {
+	if key := ev.MessageKey(); key != "" && !s.recentEvents.Add(key) {
+		log.Printf("ℹ️  Skipping duplicate %s event %s", eventName(ev), key)
+		return
+	}
+	s.dispatchTo(ev, s.printer, false)
+}
+
+// dispatchTo runs the handlers for an event with receipts going to printer.
+// A preview only captures the receipts, so handlers don't log them as queued.
+func (s *Server) dispatchTo(ev *Event, printer Printer, preview bool) {
+	if s.handlers == nil {
+		return
+	}
+
+	outcome := &printOutcome{}
+	for _, h := range s.handlers.handlersFor(ev.Keys()) {
+		hc := &HandlerContext{Event: ev, server: s, printer: printer, outcome: outcome, preview: preview}
+		if err := h.Handle(hc); err != nil {
+			log.Printf("⚠️  Handler %s failed: %v", h.Name(), err)
+			if outcome.status != PrintOutcomePrinted {
+				outcome.status = PrintOutcomeFailed
+				outcome.reason = fmt.Sprintf("%s: %v", h.Name(), err)
+			}
+		}
+		if outcome.stopped {
+			break
+		}
+	}
+
+	s.recordPrintOutcome(ev, outcome)
+}
+
+// recordPrintOutcome stores on the event's stream_events row whether a receipt was printed
+func (s *Server) recordPrintOutcome(ev *Event, outcome *printOutcome) {
+	if ev.StoredID == 0 || s.eventStore == nil {
+		return
+	}
+	if outcome.status == "" {
+		outcome.status = PrintOutcomeSkipped
+		outcome.reason = "no handler prints this event"
+	}
+	if err := s.eventStore.SetPrintOutcome(ev.StoredID, outcome.status, outcome.reason); err != nil {
+		log.Printf("⚠️  Failed to record print outcome: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/tyrm/joysticktv-receipt-bot/fakejoystick"
+)
+
+// routedPrinter records the printers receipts were sent to and fails on the
+// ones listed in fail
+type routedPrinter struct {
+	fail    map[string]bool
+	printed []string
+}
+
+// PrintReceipt records the receipt's printer unless it is set to fail
+func (rp *routedPrinter) PrintReceipt(r *Receipt) error {
+	if rp.fail[r.Printer] {
+		return errors.New("unreachable")
+	}
+	rp.printed = append(rp.printed, r.Printer)
+	return nil
+}
+
+func TestPrintReceiptOutcome(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		route   []string
+		fail    []string
+		status  string
+		printed int
+		wantErr bool
+	}{
+		{"every printer", []string{"front", "back"}, nil, PrintOutcomePrinted, 2, false},
+		{"later printer fails", []string{"front", "back"}, []string{"back"}, PrintOutcomePrinted, 1, false},
+		{"earlier printer fails", []string{"front", "back"}, []string{"front"}, PrintOutcomePrinted, 1, false},
+		{"every printer fails", []string{"front", "back"}, []string{"front", "back"}, "", 0, true},
+		{"no printer routed", []string{}, nil, PrintOutcomeSkipped, 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			printers, err := NewPrinterSet([]PrinterConfig{
+				{Name: "front", Addr: "127.0.0.1:1"},
+				{Name: "back", Addr: "127.0.0.1:1"},
+			})
+			if err != nil {
+				t.Fatalf("NewPrinterSet: %v", err)
+			}
+			if err := printers.SetRoutes(map[string][]string{"tipped": tc.route}); err != nil {
+				t.Fatalf("SetRoutes: %v", err)
+			}
+
+			printer := &routedPrinter{fail: map[string]bool{}}
+			for _, name := range tc.fail {
+				printer.fail[name] = true
+			}
+			outcome := &printOutcome{}
+			hc := &HandlerContext{
+				Event:   &Event{Kind: KindStreamEvent, Type: "tipped"},
+				server:  &Server{printers: printers},
+				printer: printer,
+				outcome: outcome,
+				preview: true,
+			}
+
+			err = hc.PrintReceipt(&Receipt{})
+			if (err != nil) != tc.wantErr {
+				t.Errorf("PrintReceipt error = %v, want error %t", err, tc.wantErr)
+			}
+			if outcome.status != tc.status {
+				t.Errorf("outcome = %q, want %q", outcome.status, tc.status)
+			}
+			if len(printer.printed) != tc.printed {
+				t.Errorf("printed on %v, want %d printer(s)", printer.printed, tc.printed)
+			}
+		})
+	}
+}
+
+func TestHandlersWithoutPrinter(t *testing.T) {
+	tmpl, err := ParseNotificationTemplate("Header", "{{.Text}}")
+	if err != nil {
+		t.Fatalf("ParseNotificationTemplate: %v", err)
+	}
+	menuTip := decodeFrame(t, fakejoystick.Tipped("alice", 100, "Spin the Wheel", ""))
+	plainTip := decodeFrame(t, fakejoystick.Tipped("alice", 100, "", "hi"))
+
+	for _, tc := range []struct {
+		name    string
+		handler EventHandler
+		ev      *Event
+		status  string
+	}{
+		{"tip", &tipHandler{tmpl: tmpl}, menuTip, PrintOutcomeSkipped},
+		{"tip leaves plain tips alone", &tipHandler{tmpl: tmpl}, plainTip, ""},
+		{"plain tip", &plainTipHandler{tmpl: tmpl}, plainTip, PrintOutcomeSkipped},
+		{"plain tip leaves menu tips alone", &plainTipHandler{tmpl: tmpl}, menuTip, ""},
+		{"follow", &followHandler{tmpl: tmpl}, decodeFrame(t, fakejoystick.Followed("bob")), PrintOutcomeSkipped},
+		{"subscribe", &subscribedHandler{tmpl: tmpl}, decodeFrame(t, fakejoystick.Subscribed("carol")), PrintOutcomeSkipped},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			outcome := &printOutcome{}
+			hc := &HandlerContext{Event: tc.ev, server: &Server{}, outcome: outcome, preview: true}
+			if err := tc.handler.Handle(hc); err != nil {
+				t.Fatalf("Handle: %v", err)
+			}
+			if outcome.status != tc.status {
+				t.Errorf("outcome = %+v, want status %q", outcome, tc.status)
+			}
+			if tc.status != "" && outcome.reason != "no printer configured" {
+				t.Errorf("reason = %q, want %q", outcome.reason, "no printer configured")
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultJoystickBaseURL is the base URL of the Joystick TV HTTP API
+const DefaultJoystickBaseURL = "https://joystick.tv"
+
+// JoystickClient routes all traffic to Joystick TV: the OAuth endpoints and the
+// ActionCable gateway. The endpoints, HTTP client and WebSocket dialer can be
+// replaced to run against a staging or stand-in server.
+type JoystickClient struct {
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	baseURL      *url.URL
+	wsURL        *url.URL
+
+	// HTTPClient is used for OAuth requests
+	HTTPClient *http.Client
+	// Dialer is used to connect to the gateway WebSocket
+	Dialer *websocket.Dialer
+}
+
+// tokenResponse is the response body of the Joystick TV token endpoint
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	TokenType    string `json:"token_type"`
+}
+
+// TokenError is returned when the token endpoint answers with a non-200 status
+// or without an access token
+type TokenError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *TokenError) Error() string {
+	return fmt.Sprintf("token request failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// Temporary reports whether the request may succeed if retried later
+func (e *TokenError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// NewJoystickClient creates a client for the public Joystick TV endpoints
+func NewJoystickClient(clientID, clientSecret, redirectURL string) *JoystickClient {
+	jc := &JoystickClient{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		HTTPClient:   &http.Client{Timeout: 30 * time.Second},
+		Dialer: &websocket.Dialer{
+			HandshakeTimeout: 45 * time.Second,
+		},
+	}
+	if err := jc.SetEndpoints(DefaultJoystickBaseURL, ""); err != nil {
+		panic(err) // the default endpoints always parse
+	}
+	return jc
+}
+
+// SetEndpoints changes the HTTP base URL and the gateway WebSocket URL. An
+// empty wsURL is derived from the base URL (https://host becomes wss://host/cable).
+func (jc *JoystickClient) SetEndpoints(baseURL, wsURL string) error {
+	base, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return fmt.Errorf("invalid base URL %q: %w", baseURL, err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return fmt.Errorf("invalid base URL %q: scheme must be http or https", baseURL)
+	}
+
+	var ws *url.URL
+	if wsURL == "" {
+		ws = &url.URL{Scheme: "wss", Host: base.Host, Path: base.Path + "/cable"}
+		if base.Scheme == "http" {
+			ws.Scheme = "ws"
+		}
+	} else {
+		ws, err = url.Parse(wsURL)
+		if err != nil {
+			return fmt.Errorf("invalid WebSocket URL %q: %w", wsURL, err)
+		}
+		if ws.Scheme != "ws" && ws.Scheme != "wss" {
+			return fmt.Errorf("invalid WebSocket URL %q: scheme must be ws or wss", wsURL)
+		}
+	}
+
+	jc.baseURL = base
+	jc.wsURL = ws
+	return nil
+}
+
+// BaseURL returns the HTTP base URL
+func (jc *JoystickClient) BaseURL() string {
+	return jc.baseURL.String()
+}
+
+// WebSocketURL returns the gateway WebSocket URL
+func (jc *JoystickClient) WebSocketURL() string {
+	return jc.wsURL.String()
+}
+
+// endpoint returns the absolute URL of an API path
+func (jc *JoystickClient) endpoint(path string) *url.URL {
+	u := *jc.baseURL
+	u.Path = jc.baseURL.Path + path
+	return &u
+}
+
+// AuthorizeURL returns the OAuth authorization URL the user is redirected to
+func (jc *JoystickClient) AuthorizeURL(state string) string {
+	u := jc.endpoint("/api/oauth/authorize")
+	q := url.Values{}
+	q.Set("client_id", jc.clientID)
+	q.Set("redirect_uri", jc.redirectURL)
+	q.Set("state", state)
+	q.Set("response_type", "code")
+	q.Set("scope", "bot")
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// basicAuth returns the base64 encoded "client id:client secret" pair
+func basicAuth(clientID, clientSecret string) string {
+	return base64.StdEncoding.EncodeToString([]byte(clientID + ":" + clientSecret))
+}
+
+// RequestToken posts a grant to the Joystick TV token endpoint
+func (jc *JoystickClient) RequestToken(ctx context.Context, data url.Values) (*tokenResponse, error) {
+	req, err := http.NewRequestWithContext(
+		ctx,
+		"POST",
+		jc.endpoint("/api/oauth/token").String(),
+		strings.NewReader(data.Encode()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Basic "+basicAuth(jc.clientID, jc.clientSecret))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := jc.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &TokenError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	var tokenResp tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return nil, fmt.Errorf("failed to parse token response: %w", err)
+	}
+	if tokenResp.AccessToken == "" {
+		// Retrying won't help, so this isn't reported as temporary
+		return nil, &TokenError{StatusCode: resp.StatusCode, Body: "response did not include an access token"}
+	}
+
+	return &tokenResp, nil
+}
+
+// DialGateway opens the ActionCable WebSocket, authenticating with the
+// given client credentials
+func (jc *JoystickClient) DialGateway(ctx context.Context, clientID, clientSecret string) (*websocket.Conn, error) {
+	u := *jc.wsURL
+	q := u.Query()
+	q.Set("token", basicAuth(clientID, clientSecret))
+	u.RawQuery = q.Encode()
+
+	ws, _, err := jc.Dialer.DialContext(ctx, u.String(), http.Header{
+		"Sec-WebSocket-Protocol": []string{"actioncable-v1-json"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
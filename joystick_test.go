@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/tyrm/joysticktv-receipt-bot/fakejoystick"
+	"tyr.codes/golib/receipt/template"
+)
+
+const (
+	testClientID     = "test-client"
+	testClientSecret = "test-secret"
+	testRedirectURL  = "http://localhost/callback"
+)
+
+// chanPrinter hands printed receipts to the test through a channel
+type chanPrinter chan *Receipt
+
+// PrintReceipt sends the receipt on the channel
+func (cp chanPrinter) PrintReceipt(r *Receipt) error {
+	cp <- r
+	return nil
+}
+
+// newTestServer starts a fake Joystick TV server and a bot server pointed at
+// it, with the tip handler printing to the returned channel
+func newTestServer(t *testing.T) (*Server, *fakejoystick.Server, chanPrinter) {
+	t.Helper()
+
+	fs := fakejoystick.New(testClientID, testClientSecret)
+	fs.PingInterval = 0
+	ts := httptest.NewServer(fs)
+	t.Cleanup(ts.Close)
+
+	s := NewServer(testClientID, testClientSecret, testRedirectURL, t.TempDir()+"/credentials.json")
+	if err := s.joystick.SetEndpoints(ts.URL, ""); err != nil {
+		t.Fatalf("SetEndpoints: %v", err)
+	}
+	s.reconnectPolicy = ReconnectPolicy{InitialDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond, Multiplier: 2}
+	s.heartbeatTimeout = 5 * time.Second
+
+	printer := make(chanPrinter, 10)
+	s.printer = printer
+	tmpl, err := ParseNotificationTemplate(defaultTipHeader, defaultTipMessage)
+	if err != nil {
+		t.Fatalf("ParseNotificationTemplate: %v", err)
+	}
+	if err := s.handlers.Register(&tipHandler{tmpl: tmpl}); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.processEvents(ctx)
+	t.Cleanup(cancel)
+
+	t.Cleanup(s.StopWebSocket)
+	return s, fs, printer
+}
+
+// authorizeCode walks the fake authorize endpoint and returns the code it
+// redirects back with
+func authorizeCode(t *testing.T, s *Server) string {
+	t.Helper()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(s.joystick.AuthorizeURL("test-state"))
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize status = %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("authorize redirect: %v", err)
+	}
+	return location.Query().Get("code")
+}
+
+// signIn signs the server in through the code exchange
+func signIn(t *testing.T, s *Server) {
+	t.Helper()
+
+	if err := s.ExchangeCodeForToken(authorizeCode(t, s)); err != nil {
+		t.Fatalf("ExchangeCodeForToken: %v", err)
+	}
+}
+
+// connect signs the server in and starts the WebSocket, waiting until the
+// subscription is confirmed
+func connect(t *testing.T, s *Server, fs *fakejoystick.Server) {
+	t.Helper()
+
+	signIn(t, s)
+	s.StartWebSocket()
+	waitFor(t, "subscription", func() bool {
+		return fs.Subscribers() == 1 && s.ConnectionStatus().State == ConnectionConnected
+	})
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// receiveNotification waits for the next printed notification
+func receiveNotification(t *testing.T, printer chanPrinter) *template.StreamerNotification {
+	t.Helper()
+
+	select {
+	case r := <-printer:
+		return r.Notification
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a receipt")
+		return nil
+	}
+}
+
+// hasConnectionEvent reports whether the connection went through state
+func hasConnectionEvent(s *Server, state ConnectionState) bool {
+	for _, ev := range s.ConnectionStatus().Events {
+		if ev.State == state {
+			return true
+		}
+	}
+	return false
+}
+
+func TestExchangeCodeForToken(t *testing.T) {
+	s, fs, _ := newTestServer(t)
+	signIn(t, s)
+
+	creds := s.credentials
+	if creds.AccessToken == "" || creds.RefreshToken == "" {
+		t.Errorf("credentials = %+v, want access and refresh tokens", creds)
+	}
+	if creds.ClientID != testClientID || creds.ClientSecret != testClientSecret {
+		t.Errorf("client credentials = %q/%q, want %q/%q", creds.ClientID, creds.ClientSecret, testClientID, testClientSecret)
+	}
+	if until := time.Until(creds.ExpiresAt); until < 59*time.Minute || until > time.Hour {
+		t.Errorf("token expires in %s, want about an hour", until)
+	}
+
+	requests := fs.TokenRequests()
+	if len(requests) != 1 {
+		t.Fatalf("got %d token requests, want 1", len(requests))
+	}
+	req := requests[0]
+	if req.GrantType != "authorization_code" || req.Status != http.StatusOK {
+		t.Errorf("token request = %s (status %d), want authorization_code (status 200)", req.GrantType, req.Status)
+	}
+	if got := req.Form.Get("redirect_uri"); got != testRedirectURL {
+		t.Errorf("redirect_uri = %q, want %q", got, testRedirectURL)
+	}
+
+	// Codes are single use
+	var tokenErr *TokenError
+	if err := s.ExchangeCodeForToken(req.Form.Get("code")); !errors.As(err, &tokenErr) || tokenErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("reusing the code returned %v, want a 400 TokenError", err)
+	}
+}
+
+func TestConnectSubscribes(t *testing.T) {
+	s, fs, _ := newTestServer(t)
+	connect(t, s, fs)
+
+	if s.ConnectionStatus().ConnectedAt.IsZero() {
+		t.Error("ConnectedAt is not set after the subscription was confirmed")
+	}
+	if got := fs.Connects(); got != 1 {
+		t.Errorf("fake server accepted %d connections, want 1", got)
+	}
+}
+
+func TestRejectedSubscription(t *testing.T) {
+	s, fs, _ := newTestServer(t)
+	fs.RejectSubscriptions = true
+	signIn(t, s)
+	s.StartWebSocket()
+
+	waitFor(t, "the gateway connection", func() bool { return fs.Connects() == 1 })
+	time.Sleep(200 * time.Millisecond)
+
+	status := s.ConnectionStatus()
+	if status.State == ConnectionConnected || !status.ConnectedAt.IsZero() {
+		t.Errorf("connection state = %s after the subscription was rejected", status.State)
+	}
+	if got := fs.Subscribers(); got != 0 {
+		t.Errorf("fake server has %d subscribers, want 0", got)
+	}
+}
+
+func TestTipReachesTipHandler(t *testing.T) {
+	s, fs, printer := newTestServer(t)
+	connect(t, s, fs)
+
+	fs.Send(fakejoystick.Tipped("alice", 50, "Spin the wheel", ""))
+
+	n := receiveNotification(t, printer)
+	if n.Header != "New Tip" || n.Message != "Spin the wheel" || n.Username != "alice" {
+		t.Errorf("notification = %q / %q / %q, want %q / %q / %q", n.Header, n.Message, n.Username, "New Tip", "Spin the wheel", "alice")
+	}
+}
+
+func TestMalformedFrameIsSkipped(t *testing.T) {
+	s, fs, printer := newTestServer(t)
+	connect(t, s, fs)
+
+	// An envelope that doesn't decode, then a stream event with broken metadata
+	fs.Send(
+		fakejoystick.Frame{"type": 42},
+		fakejoystick.Message(map[string]interface{}{"event": "StreamEvent", "type": "tipped", "metadata": "{not json"}),
+		fakejoystick.Tipped("bob", 100, "Hydrate", ""),
+	)
+
+	if n := receiveNotification(t, printer); n.Username != "bob" {
+		t.Errorf("receipt for %q, want bob", n.Username)
+	}
+	if got := fs.Connects(); got != 1 {
+		t.Errorf("fake server accepted %d connections, want 1", got)
+	}
+}
+
+func TestReconnectAfterDrop(t *testing.T) {
+	s, fs, printer := newTestServer(t)
+	connect(t, s, fs)
+
+	fs.DropConnections()
+	waitFor(t, "reconnect", func() bool {
+		return fs.Connects() == 2 && fs.Subscribers() == 1 && s.ConnectionStatus().State == ConnectionConnected
+	})
+
+	// Events on the new connection are still handled
+	fs.Send(fakejoystick.Tipped("bob", 100, "Hydrate", ""))
+	if n := receiveNotification(t, printer); n.Username != "bob" {
+		t.Errorf("receipt for %q, want bob", n.Username)
+	}
+}
+
+func TestHeartbeatStallReconnects(t *testing.T) {
+	s, fs, _ := newTestServer(t)
+	s.heartbeatTimeout = 200 * time.Millisecond
+	connect(t, s, fs)
+
+	// Pings are off, so the connection goes silent and must be replaced
+	waitFor(t, "reconnect after the stall", func() bool {
+		return fs.Connects() >= 2 && hasConnectionEvent(s, ConnectionStalled)
+	})
+}
@@ -1,17 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	_ "embed"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
-
-	"github.com/gorilla/websocket"
 )
 
 //go:embed joysticktv.png
@@ -30,26 +32,51 @@ type Credentials struct {
 type AuthState struct {
 	State   string
 	Created time.Time
+	// Session is the admin session that started the flow; empty when it was
+	// started with an API token
+	Session string
 }
 
 // Server holds the web server configuration
 type Server struct {
-	clientID     string
-	clientSecret string
-	redirectURL  string
-	credFile     string
-	credentials  *Credentials
-	credMutex    sync.RWMutex
-	authStates   map[string]AuthState
-	statesMutex  sync.RWMutex
-	db           *AppDatabase
-	thumbCache   *ThumbnailCache
-	eventStore   *StreamEventStore
-	printerAddr  string
+	clientID      string
+	clientSecret  string
+	redirectURL   string
+	credFile      string
+	credentials   *Credentials
+	credMutex     sync.RWMutex
+	authStates    map[string]AuthState
+	statesMutex   sync.RWMutex
+	db            *AppDatabase
+	thumbCache    *ThumbnailCache
+	eventStore    *StreamEventStore
+	chatStore     *ChatMessageStore
+	presenceStore *PresenceStore
+	viewerStore   *ViewerStore
+	printers      *PrinterSet
+	printQueue    *PrintQueue
+	printer       Printer
+	handlers      *HandlerRegistry
+	recentEvents  *recentEvents
+	broadcaster   *EventBroadcaster
+	events        *eventQueue // decoded events waiting for processEvents
+	admin         *AdminAuth
+	joystick      *JoystickClient
+
+	reconnectPolicy  ReconnectPolicy
+	heartbeatTimeout time.Duration
+	connStatus       ConnectionStatus
+	connCancel       context.CancelFunc
+	connDone         chan struct{}
+	connMutex        sync.RWMutex
+
+	credsChanged  chan struct{}
+	refreshStatus TokenRefreshStatus
+	refreshMutex  sync.RWMutex
 }
 
 // NewServer creates a new server instance
-func NewServer(clientID, clientSecret, redirectURL, credFile, printerAddr string) *Server {
+func NewServer(clientID, clientSecret, redirectURL, credFile string) *Server {
 	return &Server{
 		clientID:     clientID,
 		clientSecret: clientSecret,
@@ -57,38 +84,49 @@ func NewServer(clientID, clientSecret, redirectURL, credFile, printerAddr string
 		credFile:     credFile,
 		credentials:  &Credentials{},
 		authStates:   make(map[string]AuthState),
-		printerAddr:  printerAddr,
+		handlers:     NewHandlerRegistry(),
+		recentEvents: newRecentEvents(maxRecentEvents),
+		broadcaster:  NewEventBroadcaster(),
+		events:       newEventQueue(),
+		joystick:     NewJoystickClient(clientID, clientSecret, redirectURL),
+
+		reconnectPolicy:  DefaultReconnectPolicy(),
+		heartbeatTimeout: DefaultHeartbeatTimeout,
+
+		credsChanged: make(chan struct{}, 1),
 	}
 }
 
 // ConnectToWebSocket connects to the Joystick TV WebSocket API and listens for events
-func (s *Server) ConnectToWebSocket() error {
+// until the connection fails or the context is cancelled
+func (s *Server) ConnectToWebSocket(ctx context.Context) error {
 	s.credMutex.RLock()
 	clientID := s.credentials.ClientID
 	clientSecret := s.credentials.ClientSecret
 	s.credMutex.RUnlock()
 
 	if clientID == "" || clientSecret == "" {
-		return fmt.Errorf("missing credentials for WebSocket connection")
-	}
-
-	// Create basic auth token (Client ID:Client Secret in Base64)
-	basicAuth := base64.StdEncoding.EncodeToString([]byte(clientID + ":" + clientSecret))
-
-	// Connect to WebSocket
-	wsURL := fmt.Sprintf("wss://joystick.tv/cable?token=%s", basicAuth)
-	dialer := websocket.Dialer{
-		HandshakeTimeout: 45 * time.Second,
+		return errMissingCredentials
 	}
 
-	ws, _, err := dialer.Dial(wsURL, http.Header{
-		"Sec-WebSocket-Protocol": []string{"actioncable-v1-json"},
-	})
+	// Connect to WebSocket, authenticating with basic auth (Client ID:Client Secret in Base64)
+	ws, err := s.joystick.DialGateway(ctx, clientID, clientSecret)
 	if err != nil {
 		return fmt.Errorf("failed to connect to WebSocket: %w", err)
 	}
 	defer ws.Close()
 
+	// Close the socket when the context is cancelled to unblock ReadJSON
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ws.Close()
+		case <-stop:
+		}
+	}()
+
 	log.Printf("✓ Connected to Joystick TV WebSocket API")
 
 	// Subscribe to GatewayChannel
@@ -102,128 +140,294 @@ func (s *Server) ConnectToWebSocket() error {
 
 	log.Printf("ℹ️  Sent subscription request to GatewayChannel")
 
-	// Listen for events
+	// Listen for events, treating a silent connection as dead once the heartbeat window passes
 	for {
-		var msg map[string]interface{}
-		if err := ws.ReadJSON(&msg); err != nil {
+		if s.heartbeatTimeout > 0 {
+			if err := ws.SetReadDeadline(time.Now().Add(s.heartbeatTimeout)); err != nil {
+				return fmt.Errorf("failed to set read deadline: %w", err)
+			}
+		}
+
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if isTimeout(err) {
+				return s.recordStall()
+			}
 			log.Printf("⚠️  WebSocket connection closed: %v", err)
 			return err
 		}
 
+		frame, err := DecodeGatewayMessage(data)
+		if err != nil {
+			log.Printf("⚠️  Ignoring malformed WebSocket frame: %v", err)
+			s.recordFrame(false)
+			continue
+		}
+		s.recordFrame(frame.Type == "ping")
+
 		// Output all events
-		s.outputEvent(msg)
+		s.outputEvent(frame)
 	}
 }
 
 // outputEvent formats and outputs received events
-func (s *Server) outputEvent(msg map[string]interface{}) {
+func (s *Server) outputEvent(frame *GatewayMessage) {
 	// Check message type for control messages
-	msgType, ok := msg["type"].(string)
-	if ok {
-		switch msgType {
-		case "confirm_subscription":
-			log.Printf("✓ Successfully subscribed to GatewayChannel")
-			return
-		case "reject_subscription":
-			log.Printf("❌ Subscription rejected - authentication failed")
-			return
-		case "welcome":
-			log.Printf("👋 Received welcome message")
-			return
-		case "ping":
-			// Silently ignore ping messages (connection heartbeats)
-			return
-		}
+	switch frame.Type {
+	case "confirm_subscription":
+		log.Printf("✓ Successfully subscribed to GatewayChannel")
+		s.setConnectionState(ConnectionConnected, "subscribed to GatewayChannel")
+		return
+	case "reject_subscription":
+		log.Printf("❌ Subscription rejected - authentication failed")
+		return
+	case "welcome":
+		log.Printf("👋 Received welcome message")
+		return
+	case "ping":
+		// Ping messages are connection heartbeats, already tracked by the read loop
+		return
 	}
 
-	// Store StreamEvent messages in the database (after control messages have returned)
-	if s.eventStore != nil {
-		go func() {
-			if err := s.eventStore.StoreEvent(msg); err != nil {
-				log.Printf("⚠️  Failed to store stream event: %v", err)
-			}
-		}()
+	// Decode the channel message once for the store, thumbnail cache and handlers
+	ev, err := DecodeEvent(frame)
+	if err != nil {
+		log.Printf("⚠️  Failed to decode event: %v", err)
 	}
 
-	// Handle tipped stream events (print receipt notification)
-	if message, ok := msg["message"].(map[string]interface{}); ok {
-		if event, ok := message["event"].(string); ok && event == "StreamEvent" {
-			if eventType, ok := message["type"].(string); ok {
-				switch eventType {
-				case "tipped":
-					go s.HandleTippedEvent(msg)
-				case "followed":
-					go s.HandleFollowedEvent(msg)
-				case "subscribed":
-					go s.HandleSubscribedEvent(msg)
-				}
+	if ev != nil {
+		s.recordEvent(ev)
+
+		// Hand the event to the event worker, which processes events one at a
+		// time in the order they arrived
+		s.events.Push(ev)
+
+		// Check for author photo thumbnail and cache it
+		if author := ev.Author(); author != nil && author.SignedPhotoThumbURL != "" {
+			username := author.Name()
+			if username == "" {
+				username = "unknown"
 			}
-		}
-	}
 
-	// Check for author photo thumbnail and cache it
-	if message, ok := msg["message"].(map[string]interface{}); ok {
-		if author, ok := message["author"].(map[string]interface{}); ok {
-			if thumbURL, ok := author["signedPhotoThumbUrl"].(string); ok && thumbURL != "" {
-				var username string
-				if slug, ok := author["slug"].(string); ok {
-					username = slug
-				} else if usr, ok := author["username"].(string); ok {
-					username = usr
-				} else {
-					username = "unknown"
-				}
-
-				// Download and cache thumbnail in background to avoid blocking event processing
-				if s.thumbCache != nil {
-					go func() {
-						if err := s.thumbCache.DownloadAndStore(thumbURL, username); err != nil {
-							log.Printf("⚠️  Thumbnail cache error for user %s: %v", username, err)
-						}
-					}()
-				}
+			// Download and cache thumbnail in background to avoid blocking event processing
+			if s.thumbCache != nil {
+				go func() {
+					if err := s.thumbCache.DownloadAndStore(author.SignedPhotoThumbURL, username); err != nil {
+						log.Printf("⚠️  Thumbnail cache error for user %s: %v", username, err)
+					}
+				}()
 			}
 		}
 	}
 
 	// Output raw event
-	eventJSON, err := json.MarshalIndent(msg, "", "  ")
-	if err != nil {
-		log.Printf("❌ Failed to marshal event: %v", err)
+	var eventJSON bytes.Buffer
+	if err := json.Indent(&eventJSON, frame.Raw, "", "  "); err != nil {
+		log.Printf("❌ Failed to format event: %v", err)
+		return
+	}
+
+	log.Printf("📨 Event received:\n%s", eventJSON.String())
+}
+
+// processEvents stores, publishes and dispatches queued events in the order
+// they arrived until the context is cancelled. Only one may run, so stream
+// event ids, the live feed and receipts all follow arrival order.
+func (s *Server) processEvents(ctx context.Context) {
+	for {
+		ev := s.events.Pop(ctx)
+		if ev == nil {
+			return
+		}
+		s.processEvent(ev)
+	}
+}
+
+// processEvent stores an event, sends it to live feed subscribers and runs
+// its handlers
+func (s *Server) processEvent(ev *Event) {
+	// Store the event first so handlers can record on the stream_events
+	// row whether a receipt was printed
+	if err := s.storeEvent(ev); errors.Is(err, errDuplicateEvent) {
+		log.Printf("ℹ️  Skipping duplicate %s event %s", eventName(ev), ev.MessageKey())
 		return
+	} else if err != nil {
+		log.Printf("⚠️  Failed to store %s event: %v", eventName(ev), err)
+	} else if s.viewerStore != nil {
+		// Only events stored for the first time count towards the
+		// viewer profile, so redeliveries aren't counted twice
+		if err := s.viewerStore.RecordEvent(ev); err != nil {
+			log.Printf("⚠️  Failed to update viewer profile: %v", err)
+		}
 	}
 
-	log.Printf("📨 Event received:\n%s", string(eventJSON))
+	// Send the event to live feed subscribers
+	s.broadcaster.Publish(ev)
+
+	// Pass the decoded event to the registered handlers (receipt printing etc.)
+	s.Dispatch(ev)
 }
 
-// HandleRoot serves a simple home page
-func (s *Server) HandleRoot(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	fmt.Fprint(w, `
-		<!DOCTYPE html>
-		<html>
-		<head>
-			<title>Joystick TV API Server</title>
-			<style>
-				body { font-family: Arial, sans-serif; margin: 50px; }
-				h1 { color: #333; }
-				a { color: #3498db; margin-right: 20px; text-decoration: none; }
-				a:hover { text-decoration: underline; }
-			</style>
-		</head>
-		<body>
-			<h1>🎮 Joystick TV API Server</h1>
-			<p>Welcome to the Joystick TV authentication server.</p>
-			<p>
-				<a href="/login">Authenticate</a>
-				<a href="/status">View Status</a>
-			</p>
-		</body>
-		</html>
-	`)
+// storeEvent writes an event to the table for its kind: stream events to
+// stream_events (setting ev.StoredID), chat messages to chat_messages and
+// presence events to user_presence. Returns errDuplicateEvent for a message
+// that was stored before.
+func (s *Server) storeEvent(ev *Event) error {
+	switch {
+	case ev.Kind == KindStreamEvent && s.eventStore != nil:
+		id, err := s.eventStore.StoreEvent(ev)
+		ev.StoredID = id
+		return err
+	case ev.Kind == KindChatMessage && s.chatStore != nil:
+		_, err := s.chatStore.StoreMessage(ev)
+		return err
+	case ev.Kind == KindUserPresence && s.presenceStore != nil:
+		_, err := s.presenceStore.StorePresence(ev)
+		return err
+	}
+	return nil
+}
+
+// tipPolicyFromEnv reads tip amount rules from the <prefix>_MIN_AMOUNT and <prefix>_TIERS variables
+func tipPolicyFromEnv(prefix string) TipPolicy {
+	var policy TipPolicy
+	if v := os.Getenv(prefix + "_MIN_AMOUNT"); v != "" {
+		minAmount, err := strconv.Atoi(v)
+		if err != nil || minAmount < 0 {
+			log.Fatalf("❌ Invalid %s_MIN_AMOUNT: %q", prefix, v)
+		}
+		policy.MinAmount = minAmount
+	}
+
+	tiers, err := ParseTipTiers(os.Getenv(prefix + "_TIERS"))
+	if err != nil {
+		log.Fatalf("❌ Invalid %s_TIERS: %v", prefix, err)
+	}
+	policy.Tiers = tiers
+	return policy
+}
+
+// notificationTemplateFromEnv reads receipt templates from the <prefix>_HEADER and
+// <prefix>_MESSAGE variables, falling back to the handler's defaults
+func notificationTemplateFromEnv(prefix, header, message string) *NotificationTemplate {
+	if v, ok := os.LookupEnv(prefix + "_HEADER"); ok {
+		header = v
+	}
+	if v, ok := os.LookupEnv(prefix + "_MESSAGE"); ok {
+		message = v
+	}
+
+	tmpl, err := ParseNotificationTemplate(header, message)
+	if err != nil {
+		log.Fatalf("❌ Invalid %s receipt template: %v", prefix, err)
+	}
+	return tmpl
+}
+
+// printersFromEnv reads the printers from RECEIPT_ADDR (the "default" printer)
+// and PRINTERS, and their routes from PRINT_ROUTES. It returns nil when no
+// printer is configured.
+func printersFromEnv() *PrinterSet {
+	var configs []PrinterConfig
+	if addr := os.Getenv("RECEIPT_ADDR"); addr != "" {
+		configs = append(configs, PrinterConfig{Name: DefaultPrinterName, Addr: addr})
+	}
+	named, err := ParsePrinters(os.Getenv("PRINTERS"))
+	if err != nil {
+		log.Fatalf("❌ Invalid PRINTERS: %v", err)
+	}
+	configs = append(configs, named...)
+	if len(configs) == 0 {
+		return nil
+	}
+
+	printers, err := NewPrinterSet(configs)
+	if err != nil {
+		log.Fatalf("❌ Invalid printer configuration: %v", err)
+	}
+	routes, err := ParsePrinterRoutes(os.Getenv("PRINT_ROUTES"))
+	if err != nil {
+		log.Fatalf("❌ Invalid PRINT_ROUTES: %v", err)
+	}
+	if err := printers.SetRoutes(routes); err != nil {
+		log.Fatalf("❌ Invalid PRINT_ROUTES: %v", err)
+	}
+	return printers
+}
+
+// registerHandlers registers the event handlers, then drops any disabled through
+// DISABLED_HANDLERS. The rules handler is returned for reloading, or nil without RULES_FILE.
+func registerHandlers(server *Server) *rulesHandler {
+	handlers := []EventHandler{}
+
+	// Rules from RULES_FILE run first and take precedence over the built-in handlers
+	var rules *rulesHandler
+	if path := os.Getenv("RULES_FILE"); path != "" {
+		var err error
+		var printers []string
+		if server.printers != nil {
+			printers = server.printers.Names()
+		}
+		rules, err = newRulesHandler(path, printers)
+		if err != nil {
+			log.Fatalf("❌ Failed to load rules: %v", err)
+		}
+		log.Printf("✓ Loaded %d rule(s) from %s", len(rules.Rules().Rules), path)
+		handlers = append(handlers, rules)
+	}
+
+	tip := &tipHandler{
+		policy: tipPolicyFromEnv("TIP"),
+		tmpl:   notificationTemplateFromEnv("TIP", defaultTipHeader, defaultTipMessage),
+	}
+	plainTip := &plainTipHandler{
+		policy: tipPolicyFromEnv("PLAIN_TIP"),
+		tmpl:   notificationTemplateFromEnv("PLAIN_TIP", defaultPlainTipHeader, defaultPlainTipMessage),
+	}
+	follow := &followHandler{
+		tmpl: notificationTemplateFromEnv("FOLLOW", defaultFollowHeader, defaultFollowMessage),
+	}
+	subscribe := &subscribedHandler{
+		tmpl: notificationTemplateFromEnv("SUBSCRIBE", defaultSubscribeHeader, defaultSubscribeMessage),
+	}
+	handlers = append(handlers, tip, plainTip, follow, subscribe)
+	for _, h := range handlers {
+		if err := server.handlers.Register(h); err != nil {
+			log.Fatalf("❌ Failed to register handler: %v", err)
+		}
+	}
+	for _, name := range strings.Split(os.Getenv("DISABLED_HANDLERS"), ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if !server.handlers.Unregister(name) {
+			log.Printf("⚠️  Unknown handler in DISABLED_HANDLERS: %s", name)
+			continue
+		}
+		log.Printf("ℹ️  Handler disabled: %s", name)
+	}
+	return rules
 }
 
 func main() {
+	// Subcommands run offline against app.db
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "preview":
+			runPreview(os.Args[2:])
+			return
+		case "migrations":
+			runMigrations(os.Args[2:])
+			return
+		case "tokens":
+			runTokens(os.Args[2:])
+			return
+		}
+	}
+
 	// Get configuration from environment variables
 	clientID := os.Getenv("JOYSTICK_CLIENT_ID")
 	clientSecret := os.Getenv("JOYSTICK_CLIENT_SECRET")
@@ -252,22 +456,66 @@ func main() {
 	log.Printf("ℹ️  Redirect URL: %s", redirectURL)
 	log.Printf("ℹ️  Credentials File: %s", credFile)
 
-	// Get printer address from environment (will connect on demand)
-	printerAddr := os.Getenv("RECEIPT_ADDR")
-	if printerAddr != "" {
-		log.Printf("ℹ️  Printer address: %s (will connect on demand)", printerAddr)
+	reconnectPolicy := DefaultReconnectPolicy()
+	if v := os.Getenv("WS_RECONNECT_MAX_ATTEMPTS"); v != "" {
+		maxAttempts, err := strconv.Atoi(v)
+		if err != nil || maxAttempts < 0 {
+			log.Fatalf("❌ Invalid WS_RECONNECT_MAX_ATTEMPTS: %q", v)
+		}
+		reconnectPolicy.MaxAttempts = maxAttempts
+	}
+
+	heartbeatTimeout := DefaultHeartbeatTimeout
+	if v := os.Getenv("WS_HEARTBEAT_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout < 0 {
+			log.Fatalf("❌ Invalid WS_HEARTBEAT_TIMEOUT: %q", v)
+		}
+		heartbeatTimeout = timeout
+	}
+
+	// Get printers from environment (will connect on demand)
+	printers := printersFromEnv()
+	if printers != nil {
+		for _, p := range printers.Status() {
+			if p.Backup != "" {
+				log.Printf("ℹ️  Printer %s: %s, backup %s (will connect on demand)", p.Name, p.Addr, p.Backup)
+			} else {
+				log.Printf("ℹ️  Printer %s: %s (will connect on demand)", p.Name, p.Addr)
+			}
+		}
 	} else {
-		log.Printf("⚠️  No printer address configured (RECEIPT_ADDR environment variable)")
+		log.Printf("⚠️  No printer configured (RECEIPT_ADDR or PRINTERS environment variable)")
 	}
 
 	// Create server instance
-	server := NewServer(clientID, clientSecret, redirectURL, credFile, printerAddr)
+	server := NewServer(clientID, clientSecret, redirectURL, credFile)
+	server.printers = printers
+	server.reconnectPolicy = reconnectPolicy
+	server.heartbeatTimeout = heartbeatTimeout
+
+	// Point the bot at a different Joystick TV server (staging or a local stand-in)
+	baseURL := os.Getenv("JOYSTICK_BASE_URL")
+	wsURL := os.Getenv("JOYSTICK_WS_URL")
+	if baseURL != "" || wsURL != "" {
+		if baseURL == "" {
+			baseURL = DefaultJoystickBaseURL
+		}
+		if err := server.joystick.SetEndpoints(baseURL, wsURL); err != nil {
+			log.Fatalf("❌ Invalid Joystick TV endpoint: %v", err)
+		}
+	}
+	log.Printf("ℹ️  Joystick TV API: %s", server.joystick.BaseURL())
+	log.Printf("ℹ️  Joystick TV WebSocket: %s", server.joystick.WebSocketURL())
 
 	// Load existing credentials if available
 	if err := server.LoadCredentials(); err != nil {
 		log.Printf("⚠️  Failed to load credentials: %v", err)
 	}
 
+	// Keep the access token fresh in the background
+	server.StartTokenRefresher(context.Background())
+
 	// Initialize application database
 	appDB, err := NewAppDatabase("./app.db")
 	if err != nil {
@@ -289,26 +537,96 @@ func main() {
 	server.eventStore = NewStreamEventStore(appDB.GetDB())
 	log.Printf("✓ Stream event store initialized")
 
-	// Check if credentials exist and connect to WebSocket
-	server.credMutex.RLock()
-	hasCredentials := server.credentials.AccessToken != "" && server.credentials.ClientID != ""
-	server.credMutex.RUnlock()
+	// Initialize chat and presence stores
+	server.chatStore = NewChatMessageStore(appDB.GetDB())
+	server.presenceStore = NewPresenceStore(appDB.GetDB())
+	server.viewerStore = NewViewerStore(appDB.GetDB())
+	log.Printf("✓ Chat and presence stores initialized")
+
+	// Guard the web interface with the admin password and API tokens
+	sessionTTL := DefaultAdminSessionTTL
+	if v := os.Getenv("ADMIN_SESSION_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil || ttl <= 0 {
+			log.Fatalf("❌ Invalid ADMIN_SESSION_TTL: %q", v)
+		}
+		sessionTTL = ttl
+	}
+	tokens := NewAPITokenStore(appDB.GetDB())
+	server.admin = NewAdminAuth(os.Getenv("ADMIN_PASSWORD"), sessionTTL, tokens)
+	if tokenCount, err := tokens.CountTokens(); err != nil {
+		log.Printf("⚠️  Failed to count API tokens: %v", err)
+	} else if !server.admin.PasswordEnabled() && tokenCount == 0 {
+		log.Printf("⚠️  No ADMIN_PASSWORD or API tokens configured, the web interface can't be used until one is set")
+	} else {
+		log.Printf("✓ Admin authentication enabled (password: %t, API tokens: %d)", server.admin.PasswordEnabled(), tokenCount)
+	}
 
-	if hasCredentials {
+	// Start the print queue worker so receipts survive printer outages and restarts
+	if printers != nil {
+		server.printQueue = NewPrintQueue(appDB.GetDB(), printers)
+		if v := os.Getenv("PRINT_MAX_ATTEMPTS"); v != "" {
+			maxAttempts, err := strconv.Atoi(v)
+			if err != nil || maxAttempts < 1 {
+				log.Fatalf("❌ Invalid PRINT_MAX_ATTEMPTS: %q", v)
+			}
+			server.printQueue.maxAttempts = maxAttempts
+		}
+		server.printer = server.printQueue
+		go server.printQueue.Run(context.Background())
+		log.Printf("✓ Print queue started")
+
+		// Watch for printers going offline or running out of paper between receipts
+		probeInterval := DefaultPrinterProbeInterval
+		if v := os.Getenv("PRINTER_PROBE_INTERVAL"); v != "" {
+			interval, err := time.ParseDuration(v)
+			if err != nil || interval < 0 {
+				log.Fatalf("❌ Invalid PRINTER_PROBE_INTERVAL: %q", v)
+			}
+			probeInterval = interval
+		}
+		if probeInterval > 0 {
+			go printers.Monitor(context.Background(), probeInterval)
+		}
+	}
+
+	if rules := registerHandlers(server); rules != nil {
+		go rules.Watch(context.Background(), rulesWatchInterval)
+	}
+
+	// Process gateway events in the order they arrive
+	go server.processEvents(context.Background())
+
+	// Check if credentials exist and connect to WebSocket
+	if server.hasCredentials() {
 		log.Printf("ℹ️  Stored credentials found, connecting to WebSocket API...")
 		go func() {
 			time.Sleep(500 * time.Millisecond)
-			if err := server.ConnectToWebSocket(); err != nil {
-				log.Printf("❌ WebSocket connection error: %v", err)
-			}
+			server.StartWebSocket()
 		}()
 	}
 
-	// Register HTTP handlers
-	http.HandleFunc("/", server.HandleRoot)
-	http.HandleFunc("/login", server.HandleLogin)
+	// Register HTTP handlers. Everything but signing in, the OAuth callback
+	// (which only completes flows an admin started) and the overlay pages
+	// requires an admin.
+	admin := server.RequireAdmin
+	feed := server.RequireAdminFeed
+	http.HandleFunc("/admin/login", server.HandleAdminLogin)
+	http.HandleFunc("POST /admin/logout", server.HandleAdminLogout)
+	http.HandleFunc("GET /{$}", admin(server.HandleDashboard))
+	http.HandleFunc("/login", admin(server.HandleLogin))
 	http.HandleFunc("/callback", server.HandleCallback)
-	http.HandleFunc("/status", server.HandleStatus)
+	http.HandleFunc("GET /status", admin(server.HandleDashboard))
+	http.HandleFunc("POST /reconnect", admin(server.HandleReconnect))
+	http.HandleFunc("POST /printers/test", admin(server.HandleTestPrint))
+	http.HandleFunc("POST /events/{id}/reprint", admin(server.HandleReprint))
+	http.HandleFunc("GET /preview/{id}", admin(server.HandlePreview))
+	http.HandleFunc("GET /viewers/{slug}", admin(server.HandleViewer))
+	http.HandleFunc("GET /api/events", feed(server.HandleAPIEvents))
+	http.HandleFunc("GET /events/stream", feed(server.HandleEventStream))
+	http.HandleFunc("GET /api/viewers/top", feed(server.HandleTopTippers))
+	http.HandleFunc("GET /overlay/{name}", server.HandleOverlay)
+	http.HandleFunc("GET /thumbnails/{username}", feed(server.HandleThumbnail))
 
 	// Start server
 	addr := ":" + port
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Migration is a versioned change to the application database schema.
+// Migrations are applied in version order, each in its own transaction,
+// and recorded in the schema_migrations table.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// migrations is the schema history of app.db. Append new migrations to the
+// end with the next version; never change one that was released.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create thumbnails and stream_events",
+		SQL: `
+		-- Thumbnails table for caching user profile images
+		CREATE TABLE IF NOT EXISTS thumbnails (
+			username TEXT PRIMARY KEY NOT NULL,
+			sha256 TEXT NOT NULL,
+			file_size INTEGER NOT NULL,
+			download_timestamp INTEGER NOT NULL,
+			image_url TEXT NOT NULL,
+			file_extension TEXT NOT NULL DEFAULT '.png'
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_download_timestamp ON thumbnails(download_timestamp);
+
+		-- Stream events table for storing all received WebSocket events
+		CREATE TABLE IF NOT EXISTS stream_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			received_timestamp INTEGER NOT NULL,
+			event_type TEXT NOT NULL,
+			user_who_performed_action TEXT,
+			raw_json TEXT NOT NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_stream_events_timestamp ON stream_events(received_timestamp);
+		CREATE INDEX IF NOT EXISTS idx_stream_events_type ON stream_events(event_type);
+		CREATE INDEX IF NOT EXISTS idx_stream_events_user ON stream_events(user_who_performed_action);
+		`,
+	},
+	{
+		Version: 2,
+		Name:    "create print_jobs",
+		SQL: `
+		-- Print jobs table for the persistent receipt print queue
+		CREATE TABLE IF NOT EXISTS print_jobs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_timestamp INTEGER NOT NULL,
+			updated_timestamp INTEGER NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_timestamp INTEGER NOT NULL,
+			last_error TEXT,
+			header TEXT NOT NULL,
+			message TEXT NOT NULL,
+			username TEXT NOT NULL,
+			image_png BLOB
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_print_jobs_status ON print_jobs(status, next_attempt_timestamp);
+		`,
+	},
+	{
+		Version: 3,
+		Name:    "add print job banner, copies and cut",
+		SQL: `
+		ALTER TABLE print_jobs ADD COLUMN banner TEXT NOT NULL DEFAULT '';
+		ALTER TABLE print_jobs ADD COLUMN copies INTEGER NOT NULL DEFAULT 1;
+		ALTER TABLE print_jobs ADD COLUMN cut INTEGER NOT NULL DEFAULT 0;
+		`,
+	},
+	{
+		Version: 4,
+		Name:    "add stream event print outcome",
+		SQL: `
+		ALTER TABLE stream_events ADD COLUMN print_status TEXT;
+		ALTER TABLE stream_events ADD COLUMN print_reason TEXT;
+		`,
+	},
+	{
+		Version: 5,
+		Name:    "add print job printer",
+		SQL: `
+		ALTER TABLE print_jobs ADD COLUMN printer TEXT NOT NULL DEFAULT '';
+		`,
+	},
+	{
+		Version: 6,
+		Name:    "add stream event message key",
+		SQL: `
+		ALTER TABLE stream_events ADD COLUMN message_key TEXT;
+		CREATE UNIQUE INDEX idx_stream_events_message_key ON stream_events(message_key);
+		`,
+	},
+	{
+		Version: 7,
+		Name:    "create chat_messages and user_presence",
+		SQL: `
+		-- Chat messages sent to the stream
+		CREATE TABLE chat_messages (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			received_timestamp INTEGER NOT NULL,
+			created_timestamp INTEGER,
+			message_key TEXT,
+			channel_id TEXT,
+			username TEXT NOT NULL,
+			display_name TEXT,
+			text TEXT NOT NULL,
+			bot_command TEXT,
+			bot_command_arg TEXT,
+			mention INTEGER NOT NULL DEFAULT 0,
+			mentioned_username TEXT,
+			emotes_json TEXT,
+			visibility TEXT,
+			raw_json TEXT NOT NULL
+		);
+
+		CREATE INDEX idx_chat_messages_timestamp ON chat_messages(received_timestamp);
+		CREATE INDEX idx_chat_messages_user ON chat_messages(username);
+		CREATE UNIQUE INDEX idx_chat_messages_message_key ON chat_messages(message_key);
+
+		-- Users entering and leaving the stream
+		CREATE TABLE user_presence (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			received_timestamp INTEGER NOT NULL,
+			created_timestamp INTEGER,
+			message_key TEXT,
+			channel_id TEXT,
+			presence_type TEXT NOT NULL,
+			username TEXT NOT NULL,
+			raw_json TEXT NOT NULL
+		);
+
+		CREATE INDEX idx_user_presence_timestamp ON user_presence(received_timestamp);
+		CREATE INDEX idx_user_presence_user ON user_presence(username);
+		CREATE UNIQUE INDEX idx_user_presence_message_key ON user_presence(message_key);
+		`,
+	},
+	{
+		Version: 8,
+		Name:    "create viewers",
+		SQL: `
+		-- One row per viewer, aggregated from every event they caused. Presence
+		-- events name users by username and the others by slug, so the key is
+		-- lowercased to give both one row.
+		CREATE TABLE viewers (
+			slug TEXT PRIMARY KEY NOT NULL,
+			display_name TEXT,
+			first_seen_timestamp INTEGER NOT NULL,
+			last_seen_timestamp INTEGER NOT NULL,
+			tip_count INTEGER NOT NULL DEFAULT 0,
+			tip_tokens INTEGER NOT NULL DEFAULT 0,
+			followed_timestamp INTEGER,
+			subscribed_timestamp INTEGER,
+			subscription_months INTEGER NOT NULL DEFAULT 0,
+			chat_message_count INTEGER NOT NULL DEFAULT 0
+		);
+
+		CREATE INDEX idx_viewers_last_seen ON viewers(last_seen_timestamp);
+		CREATE INDEX idx_viewers_tip_tokens ON viewers(tip_tokens);
+
+		-- Backfill from the events stored so far
+		INSERT INTO viewers (slug, first_seen_timestamp, last_seen_timestamp, tip_count, tip_tokens,
+			followed_timestamp, subscribed_timestamp)
+		SELECT
+			lower(user_who_performed_action),
+			MIN(received_timestamp),
+			MAX(received_timestamp),
+			SUM(event_type = 'tipped'),
+			SUM(CASE WHEN event_type = 'tipped' AND json_valid(raw_json)
+				AND json_valid(json_extract(raw_json, '$.message.metadata'))
+				THEN COALESCE(json_extract(json_extract(raw_json, '$.message.metadata'), '$.how_much'), 0) ELSE 0 END),
+			MIN(CASE WHEN event_type = 'followed' THEN received_timestamp END),
+			MAX(CASE WHEN event_type = 'subscribed' THEN received_timestamp END)
+		FROM stream_events
+		WHERE user_who_performed_action IS NOT NULL AND user_who_performed_action != ''
+		GROUP BY lower(user_who_performed_action);
+
+		-- Months of each viewer's last subscription
+		UPDATE viewers SET subscription_months = COALESCE((
+			SELECT CASE WHEN json_valid(raw_json) AND json_valid(json_extract(raw_json, '$.message.metadata'))
+				THEN json_extract(json_extract(raw_json, '$.message.metadata'), '$.months') END
+			FROM stream_events
+			WHERE event_type = 'subscribed' AND lower(user_who_performed_action) = viewers.slug
+			ORDER BY received_timestamp DESC, id DESC
+			LIMIT 1
+		), 0)
+		WHERE subscribed_timestamp IS NOT NULL;
+
+		INSERT INTO viewers (slug, display_name, first_seen_timestamp, last_seen_timestamp, chat_message_count)
+		SELECT
+			lower(username),
+			MAX(COALESCE(NULLIF(CASE WHEN json_valid(raw_json) THEN json_extract(raw_json, '$.message.author.displayNameWithFlair') END, ''),
+				display_name)),
+			MIN(received_timestamp),
+			MAX(received_timestamp),
+			COUNT(*)
+		FROM chat_messages
+		WHERE username != 'unknown'
+		GROUP BY lower(username)
+		ON CONFLICT (slug) DO UPDATE SET
+			display_name = excluded.display_name,
+			first_seen_timestamp = MIN(first_seen_timestamp, excluded.first_seen_timestamp),
+			last_seen_timestamp = MAX(last_seen_timestamp, excluded.last_seen_timestamp),
+			chat_message_count = excluded.chat_message_count;
+
+		INSERT INTO viewers (slug, first_seen_timestamp, last_seen_timestamp)
+		SELECT lower(username), MIN(received_timestamp), MAX(received_timestamp)
+		FROM user_presence
+		WHERE username != ''
+		GROUP BY lower(username)
+		ON CONFLICT (slug) DO UPDATE SET
+			first_seen_timestamp = MIN(first_seen_timestamp, excluded.first_seen_timestamp),
+			last_seen_timestamp = MAX(last_seen_timestamp, excluded.last_seen_timestamp);
+		`,
+	},
+	{
+		Version: 9,
+		Name:    "create api_tokens",
+		SQL: `
+		-- Bearer tokens for the HTTP interface, stored as SHA-256 hashes
+		CREATE TABLE api_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			token_hash TEXT NOT NULL UNIQUE,
+			created_timestamp INTEGER NOT NULL,
+			last_used_timestamp INTEGER
+		);
+		`,
+	},
+}
+
+// validateMigrations checks that versions start at 1 and increase by one, and
+// that every migration has a name and SQL
+func validateMigrations(ms []Migration) error {
+	for i, m := range ms {
+		if m.Version != i+1 {
+			return fmt.Errorf("migration %q has version %d, want %d", m.Name, m.Version, i+1)
+		}
+		if m.Name == "" {
+			return fmt.Errorf("migration %d has no name", m.Version)
+		}
+		if strings.TrimSpace(m.SQL) == "" {
+			return fmt.Errorf("migration %d has no SQL", m.Version)
+		}
+	}
+	return nil
+}
+
+// AppliedMigration is a migration recorded in schema_migrations
+type AppliedMigration struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+// MigrationStatus compares the database schema with the known migrations
+type MigrationStatus struct {
+	Version int // highest applied version, 0 for a new database
+	Applied []AppliedMigration
+	Pending []Migration
+}
+
+// ensureMigrationsTable creates the schema_migrations table
+func ensureMigrationsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY NOT NULL,
+			name TEXT NOT NULL,
+			applied_timestamp INTEGER NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return nil
+}
+
+// migrationsTableExists reports whether the database has a schema_migrations table
+func migrationsTableExists(db *sql.DB) (bool, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'").Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up schema_migrations: %w", err)
+	}
+	return n > 0, nil
+}
+
+// migrationStatus reads the applied migrations and checks them against ms:
+// a database migrated by a newer build, or whose history differs from this
+// build's, is reported as an error before anything is changed. Without a
+// schema_migrations table every migration is pending.
+func migrationStatus(db *sql.DB, ms []Migration) (*MigrationStatus, error) {
+	if err := validateMigrations(ms); err != nil {
+		return nil, fmt.Errorf("invalid migrations: %w", err)
+	}
+
+	exists, err := migrationsTableExists(db)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return &MigrationStatus{Pending: ms}, nil
+	}
+
+	rows, err := db.Query("SELECT version, name, applied_timestamp FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	status := &MigrationStatus{}
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var am AppliedMigration
+		var appliedAt int64
+		if err := rows.Scan(&am.Version, &am.Name, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+		}
+		am.AppliedAt = time.Unix(appliedAt, 0)
+
+		if am.Version > len(ms) {
+			return nil, fmt.Errorf("database schema version %d is newer than this build (%d), upgrade the bot", am.Version, len(ms))
+		}
+		if known := ms[am.Version-1]; known.Name != am.Name {
+			return nil, fmt.Errorf("migration %d is %q in the database but %q in this build", am.Version, am.Name, known.Name)
+		}
+		status.Applied = append(status.Applied, am)
+		status.Version = am.Version
+		applied[am.Version] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+
+	for _, m := range ms {
+		if !applied[m.Version] {
+			status.Pending = append(status.Pending, m)
+		}
+	}
+	if len(status.Pending) > 0 && status.Pending[0].Version < status.Version {
+		return nil, fmt.Errorf("migration %d is missing from the database although %d is applied", status.Pending[0].Version, status.Version)
+	}
+	return status, nil
+}
+
+// migrate applies the pending migrations in order
+func (ad *AppDatabase) migrate() error {
+	if err := ensureMigrationsTable(ad.db); err != nil {
+		return err
+	}
+	status, err := migrationStatus(ad.db, migrations)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range status.Pending {
+		if err := applyMigration(ad.db, m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		log.Printf("ℹ️  Applied migration %d: %s", m.Version, m.Name)
+	}
+	return nil
+}
+
+// applyMigration runs one migration and records it in the same transaction
+func applyMigration(db *sql.DB, m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.SQL); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(
+		"INSERT INTO schema_migrations (version, name, applied_timestamp) VALUES (?, ?, ?)",
+		m.Version, m.Name, time.Now().Unix(),
+	); err != nil {
+		return fmt.Errorf("failed to record migration: %w", err)
+	}
+	return tx.Commit()
+}
+
+// runMigrations implements the migrations command, showing the schema version
+// of a database and the migrations a start would apply, without applying them
+func runMigrations(args []string) {
+	fs := flag.NewFlagSet("migrations", flag.ExitOnError)
+	dbPath := fs.String("db", "./app.db", "application database")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s migrations [-db app.db]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if _, err := os.Stat(*dbPath); err != nil {
+		log.Fatalf("❌ Database not found: %v", err)
+	}
+	db, err := openDatabase(*dbPath)
+	if err != nil {
+		log.Fatalf("❌ Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	status, err := migrationStatus(db, migrations)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	fmt.Printf("Database: %s\n", *dbPath)
+	fmt.Printf("Schema version: %d of %d\n", status.Version, len(migrations))
+	for _, am := range status.Applied {
+		fmt.Printf("  ✓ %3d  %-40s applied %s\n", am.Version, am.Name, am.AppliedAt.Format(time.RFC3339))
+	}
+	for _, m := range status.Pending {
+		fmt.Printf("    %3d  %-40s pending\n", m.Version, m.Name)
+	}
+	if len(status.Pending) == 0 {
+		fmt.Println("Up to date")
+	} else {
+		fmt.Printf("%d migration(s) will be applied on the next start\n", len(status.Pending))
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+// testMigrations is a short schema history for migrationStatus tests
+var testMigrations = []Migration{
+	{Version: 1, Name: "create a", SQL: `CREATE TABLE a (id INTEGER)`},
+	{Version: 2, Name: "create b", SQL: `CREATE TABLE b (id INTEGER)`},
+	{Version: 3, Name: "create c", SQL: `CREATE TABLE c (id INTEGER)`},
+}
+
+// openTestDatabase opens an empty database with a schema_migrations table
+// recording the given versions under the given names
+func openTestDatabase(t *testing.T, applied map[int]string) *sql.DB {
+	t.Helper()
+
+	db, err := openDatabase(t.TempDir() + "/app.db")
+	if err != nil {
+		t.Fatalf("openDatabase: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := ensureMigrationsTable(db); err != nil {
+		t.Fatalf("ensureMigrationsTable: %v", err)
+	}
+	for version, name := range applied {
+		if _, err := db.Exec(`INSERT INTO schema_migrations (version, name, applied_timestamp) VALUES (?, ?, 0)`, version, name); err != nil {
+			t.Fatalf("record migration %d: %v", version, err)
+		}
+	}
+	return db
+}
+
+func TestMigrationStatus(t *testing.T) {
+	db := openTestDatabase(t, map[int]string{1: "create a"})
+
+	status, err := migrationStatus(db, testMigrations)
+	if err != nil {
+		t.Fatalf("migrationStatus: %v", err)
+	}
+	if status.Version != 1 || len(status.Applied) != 1 || len(status.Pending) != 2 || status.Pending[0].Version != 2 {
+		t.Errorf("status = version %d, %d applied, %d pending, want version 1 with 2 and 3 pending", status.Version, len(status.Applied), len(status.Pending))
+	}
+}
+
+func TestMigrationStatusErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		applied map[int]string
+		want    string
+	}{
+		{"newer database", map[int]string{1: "create a", 2: "create b", 3: "create c", 4: "create d"}, "database schema version 4 is newer than this build (3)"},
+		{"name mismatch", map[int]string{1: "create a", 2: "create x"}, `migration 2 is "create x" in the database but "create b" in this build`},
+		{"gap", map[int]string{1: "create a", 3: "create c"}, "migration 2 is missing from the database although 3 is applied"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := openTestDatabase(t, tc.applied)
+			status, err := migrationStatus(db, testMigrations)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("migrationStatus = %+v, %v, want an error containing %q", status, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestMigrationsApplyToNewDatabase(t *testing.T) {
+	ad, err := NewAppDatabase(t.TempDir() + "/app.db")
+	if err != nil {
+		t.Fatalf("NewAppDatabase: %v", err)
+	}
+	defer ad.Close()
+
+	status, err := migrationStatus(ad.GetDB(), migrations)
+	if err != nil {
+		t.Fatalf("migrationStatus: %v", err)
+	}
+	if status.Version != len(migrations) || len(status.Pending) != 0 {
+		t.Errorf("status = version %d with %d pending, want version %d with none pending", status.Version, len(status.Pending), len(migrations))
+	}
+}
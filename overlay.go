@@ -0,0 +1,129 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// overlayFiles are the OBS browser-source overlays, configured in the browser
+// from their query parameters and updated from /events/stream
+//
+//go:embed overlays
+var overlayFiles embed.FS
+
+const (
+	// defaultTopTippers is how many viewers /api/viewers/top returns by default
+	defaultTopTippers = 10
+	// maxTopTippers caps the limit parameter of /api/viewers/top
+	maxTopTippers = 100
+)
+
+// HandleOverlay serves an overlay page by name (alerts, ticker, leaderboard)
+// and the script the pages share
+func (s *Server) HandleOverlay(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !strings.Contains(name, ".") {
+		name += ".html"
+	}
+
+	data, err := fs.ReadFile(overlayFiles, "overlays/"+name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	ext := name[strings.LastIndex(name, "."):]
+	w.Header().Set("Content-Type", mime.TypeByExtension(ext))
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(data)
+}
+
+// HandleThumbnail serves a user's cached profile image, or the Joystick TV
+// logo when none is cached
+func (s *Server) HandleThumbnail(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+
+	if tc := s.thumbCache; tc != nil && username != "" {
+		info, err := tc.GetThumbnailInfo(username)
+		if err != nil {
+			log.Printf("⚠️  Failed to look up thumbnail of %s: %v", username, err)
+		} else if info != nil && info.FileExtension != "" {
+			data, err := os.ReadFile(tc.GetFilePath(username, info.FileExtension))
+			if err == nil {
+				contentType := mime.TypeByExtension(info.FileExtension)
+				if contentType == "" {
+					contentType = http.DetectContentType(data)
+				}
+				w.Header().Set("Content-Type", contentType)
+				w.Header().Set("Cache-Control", "max-age=300")
+				w.Write(data)
+				return
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "max-age=60")
+	w.Write(joysticktv)
+}
+
+// apiViewer is a viewer profile as served by the JSON API
+type apiViewer struct {
+	Slug               string  `json:"slug"`
+	DisplayName        *string `json:"display_name"`
+	TipCount           int     `json:"tip_count"`
+	TipTokens          int     `json:"tip_tokens"`
+	Subscribed         bool    `json:"subscribed"`
+	SubscriptionMonths int     `json:"subscription_months"`
+	ChatMessageCount   int     `json:"chat_message_count"`
+}
+
+// HandleTopTippers serves the viewers who tipped the most tokens as JSON.
+// The limit parameter sets how many, 1 to 100 (default 10).
+func (s *Server) HandleTopTippers(w http.ResponseWriter, r *http.Request) {
+	if s.viewerStore == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "viewer store is not initialized")
+		return
+	}
+
+	limit := defaultTopTippers
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxTopTippers {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid limit: %q (must be 1 to %d)", v, maxTopTippers))
+			return
+		}
+		limit = n
+	}
+
+	viewers, err := s.viewerStore.GetTopTippers(limit)
+	if err != nil {
+		log.Printf("⚠️  Failed to query top tippers: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to query viewers")
+		return
+	}
+
+	resp := struct {
+		Viewers []apiViewer `json:"viewers"`
+	}{Viewers: make([]apiViewer, 0, len(viewers))}
+	for _, v := range viewers {
+		resp.Viewers = append(resp.Viewers, apiViewer{
+			Slug:               v.Slug,
+			DisplayName:        v.DisplayName,
+			TipCount:           v.TipCount,
+			TipTokens:          v.TipTokens,
+			Subscribed:         v.Subscribed(),
+			SubscriptionMonths: v.SubscriptionMonths,
+			ChatMessageCount:   v.ChatMessageCount,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,131 @@
+// Shared code of the OBS overlays: query parameter config, event text,
+// thumbnails and the live event feed.
+(function () {
+  'use strict';
+
+  var params = new URLSearchParams(window.location.search);
+
+  // param returns a query parameter, or fallback when it is missing
+  function param(name, fallback) {
+    var v = params.get(name);
+    return v === null || v === '' ? fallback : v;
+  }
+
+  // intParam returns a numeric query parameter, or fallback when it is
+  // missing or not a number
+  function intParam(name, fallback) {
+    var v = parseInt(params.get(name), 10);
+    return isNaN(v) ? fallback : v;
+  }
+
+  // boolParam reads 1/0, true/false and yes/no
+  function boolParam(name, fallback) {
+    var v = params.get(name);
+    if (v === null || v === '') {
+      return fallback;
+    }
+    return ['1', 'true', 'yes', 'on'].indexOf(v.toLowerCase()) !== -1;
+  }
+
+  // listParam reads a comma separated list
+  function listParam(name, fallback) {
+    var v = param(name, fallback);
+    return v.split(',').map(function (s) { return s.trim(); }).filter(Boolean);
+  }
+
+  // applyStyle sets the style parameters shared by every overlay as CSS variables
+  function applyStyle() {
+    var style = document.documentElement.style;
+    style.setProperty('--color', param('color', '#ffffff'));
+    style.setProperty('--accent', param('accent', '#f1c40f'));
+    style.setProperty('--background', param('background', 'rgba(0, 0, 0, 0.6)'));
+    style.setProperty('--font', param('font', 'Arial, sans-serif'));
+    style.setProperty('--font-size', intParam('font_size', 24) + 'px');
+  }
+
+  function formatNumber(n) {
+    return Number(n || 0).toLocaleString('en-US');
+  }
+
+  // describe returns the text shown for an event, or '' for events the
+  // overlays don't show
+  function describe(ev) {
+    var m = ev.metadata || {};
+    var who = m.who || ev.user || 'Anonymous';
+    switch (ev.type) {
+      case 'tipped':
+        var text = who + ' tipped ' + formatNumber(m.how_much) + ' tokens';
+        if (m.tip_menu_item) {
+          text += ' for ' + m.tip_menu_item;
+        }
+        return text;
+      case 'followed':
+        return who + ' followed';
+      case 'subscribed':
+        return m.months > 1 ? who + ' subscribed for ' + m.months + ' months' : who + ' subscribed';
+    }
+    return ev.text || '';
+  }
+
+  // userOf returns who performed an event, as thumbnails are cached
+  function userOf(ev) {
+    return ev.user || (ev.metadata && ev.metadata.who) || '';
+  }
+
+  // withToken adds the token query parameter the overlay was opened with, so
+  // the data endpoints accept requests from a browser source without a session
+  function withToken(url) {
+    var token = params.get('token');
+    if (!token) {
+      return url;
+    }
+    return url + (url.indexOf('?') === -1 ? '?' : '&') + 'token=' + encodeURIComponent(token);
+  }
+
+  // thumbnailURL returns the cached profile image of a user
+  function thumbnailURL(user) {
+    return withToken('/thumbnails/' + encodeURIComponent(user || 'anonymous'));
+  }
+
+  // subscribe calls onEvent for every live event of the given types.
+  // EventSource reconnects by itself and resumes with Last-Event-ID.
+  function subscribe(types, onEvent) {
+    var url = '/events/stream';
+    if (types.length > 0) {
+      url += '?type=' + encodeURIComponent(types.join(','));
+    }
+    var source = new EventSource(withToken(url));
+    source.onmessage = function (e) {
+      try {
+        onEvent(JSON.parse(e.data));
+      } catch (err) {
+        console.error('bad event', err);
+      }
+    };
+    return source;
+  }
+
+  // fetchJSON gets a JSON document
+  function fetchJSON(url) {
+    return fetch(withToken(url), { cache: 'no-store' }).then(function (resp) {
+      if (!resp.ok) {
+        throw new Error(url + ': ' + resp.status);
+      }
+      return resp.json();
+    });
+  }
+
+  window.overlay = {
+    param: param,
+    intParam: intParam,
+    boolParam: boolParam,
+    listParam: listParam,
+    applyStyle: applyStyle,
+    formatNumber: formatNumber,
+    describe: describe,
+    userOf: userOf,
+    thumbnailURL: thumbnailURL,
+    subscribe: subscribe,
+    fetchJSON: fetchJSON
+  };
+})();
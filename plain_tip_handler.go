@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Default receipt templates of the plain tip handler: the amount, followed by
+// the tip message if there is one
+const (
+	defaultPlainTipHeader  = "New Tip"
+	defaultPlainTipMessage = "{{tokens .Amount}}{{with .Text}}\n{{.}}{{end}}"
+)
+
+// plainTipHandler prints a receipt for regular tips, those not made through the
+// tip menu. It has its own amount policy, separate from menu item tips.
+type plainTipHandler struct {
+	policy TipPolicy
+	tmpl   *NotificationTemplate
+}
+
+// Name is "plain-tip", configured apart from the tip handler with the
+// PLAIN_TIP_ variables
+func (h *plainTipHandler) Name() string { return "plain-tip" }
+
+// Handles returns the tipped event; only tips without a tip menu item are
+// printed, the others are left to the tip handler
+func (h *plainTipHandler) Handles() []string { return []string{StreamEventKey("tipped")} }
+
+// Handle processes a tipped stream event without a tip menu item and queues a
+// receipt showing the amount and the tip message
+func (h *plainTipHandler) Handle(hc *HandlerContext) error {
+	// Tips with a tip menu item are printed by the tip handler
+	tip := hc.Event.Tipped
+	if tip == nil || tip.TipMenuItem != "" {
+		return nil
+	}
+
+	if !hc.RequirePrinter() {
+		return nil
+	}
+
+	username := hc.Username()
+
+	if !h.policy.Prints(tip.HowMuch) {
+		hc.Logf("ℹ️  Plain tip of %d tokens from %s is below the minimum of %d, skipping notification", tip.HowMuch, username, h.policy.MinAmount)
+		hc.Skip(fmt.Sprintf("plain tip below the minimum of %d tokens", h.policy.MinAmount))
+		return nil
+	}
+
+	notification, err := hc.Notification(h.tmpl)
+	if err != nil {
+		return fmt.Errorf("failed to render plain tip notification: %w", err)
+	}
+	receipt := &Receipt{Notification: notification}
+	if tier := h.policy.TierFor(tip.HowMuch); tier != nil {
+		if err := tier.Apply(receipt, hc.TemplateData()); err != nil {
+			return fmt.Errorf("failed to render plain tip tier: %w", err)
+		}
+	}
+
+	if err := hc.PrintReceipt(receipt); err != nil {
+		return fmt.Errorf("failed to queue plain tip notification: %w", err)
+	}
+
+	hc.Logf("✓ Plain tip notification queued for %s: %d tokens", username, tip.HowMuch)
+	return nil
+}
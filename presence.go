@@ -0,0 +1,164 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Presence types of UserPresence events
+const (
+	PresenceEnter = "enter_stream"
+	PresenceLeave = "leave_stream"
+)
+
+// PresenceRecord is a user entering or leaving the stream, stored in the database
+type PresenceRecord struct {
+	ID                int64
+	ReceivedTimestamp time.Time
+	CreatedTimestamp  *time.Time // from the gateway createdAt, if it parsed
+	MessageKey        *string
+	ChannelID         *string
+	PresenceType      string // enter_stream or leave_stream
+	Username          string
+	RawJSON           string
+}
+
+// PresenceStore stores presence events in the user_presence table
+type PresenceStore struct {
+	db *sql.DB
+}
+
+// NewPresenceStore creates a new presence store with a database connection
+func NewPresenceStore(db *sql.DB) *PresenceStore {
+	return &PresenceStore{
+		db: db,
+	}
+}
+
+// presenceColumns are the columns read into a PresenceRecord, in scan order
+const presenceColumns = `id, received_timestamp, created_timestamp, message_key, channel_id, presence_type, username, raw_json`
+
+// StorePresence stores a presence event and returns its row id. Only stores
+// UserPresence events. Returns errDuplicateEvent if the event was stored before.
+func (ps *PresenceStore) StorePresence(ev *Event) (int64, error) {
+	if ev.Kind != KindUserPresence || ev.Presence == nil {
+		return 0, nil
+	}
+	if ev.Presence.Type == "" || ev.Presence.Text == "" {
+		return 0, fmt.Errorf("presence event without type or username")
+	}
+
+	result, err := ps.db.Exec(`
+		INSERT INTO user_presence (received_timestamp, created_timestamp, message_key, channel_id, presence_type, username, raw_json)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (message_key) DO NOTHING
+	`,
+		ev.ReceivedAt.Unix(),
+		createdTimestamp(ev),
+		nullString(ev.MessageKey()),
+		nullString(string(ev.Presence.ChannelID)),
+		ev.Presence.Type,
+		ev.Presence.Text,
+		string(ev.Frame.Raw),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert presence event: %w", err)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return 0, errDuplicateEvent
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get presence event id: %w", err)
+	}
+	return id, nil
+}
+
+// GetPresenceByUser retrieves the most recent enter and leave events of a
+// user. Presence events carry the username, so the name matches in any case
+// and a slug finds them too.
+func (ps *PresenceStore) GetPresenceByUser(user string, limit int) ([]PresenceRecord, error) {
+	rows, err := ps.db.Query(`
+		SELECT `+presenceColumns+`
+		FROM user_presence
+		WHERE username = ? COLLATE NOCASE
+		ORDER BY received_timestamp DESC, id DESC
+		LIMIT ?
+	`, user, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query presence events: %w", err)
+	}
+	return scanPresence(rows)
+}
+
+// GetRecentPresence retrieves the most recent enter and leave events
+func (ps *PresenceStore) GetRecentPresence(limit int) ([]PresenceRecord, error) {
+	rows, err := ps.db.Query(`
+		SELECT `+presenceColumns+`
+		FROM user_presence
+		ORDER BY received_timestamp DESC, id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query presence events: %w", err)
+	}
+	return scanPresence(rows)
+}
+
+// GetViewersInStream returns the users whose latest presence event is
+// entering the stream, most recent first
+func (ps *PresenceStore) GetViewersInStream() ([]PresenceRecord, error) {
+	rows, err := ps.db.Query(`
+		SELECT `+presenceColumns+`
+		FROM user_presence p
+		WHERE id = (SELECT MAX(id) FROM user_presence WHERE username = p.username COLLATE NOCASE)
+			AND presence_type = ?
+		ORDER BY received_timestamp DESC, id DESC
+	`, PresenceEnter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query presence events: %w", err)
+	}
+	return scanPresence(rows)
+}
+
+// scanPresence reads presence rows and closes them
+func scanPresence(rows *sql.Rows) ([]PresenceRecord, error) {
+	defer rows.Close()
+
+	var records []PresenceRecord
+	for rows.Next() {
+		var rec PresenceRecord
+		var received int64
+		var created sql.NullInt64
+
+		err := rows.Scan(
+			&rec.ID,
+			&received,
+			&created,
+			&rec.MessageKey,
+			&rec.ChannelID,
+			&rec.PresenceType,
+			&rec.Username,
+			&rec.RawJSON,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan presence event: %w", err)
+		}
+
+		rec.ReceivedTimestamp = time.Unix(received, 0)
+		if created.Valid {
+			t := time.Unix(created.Int64, 0)
+			rec.CreatedTimestamp = &t
+		}
+		records = append(records, rec)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating presence events: %w", err)
+	}
+
+	return records, nil
+}
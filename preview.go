@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"image/png"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/tyrm/joysticktv-receipt-bot/fakeprinter"
+)
+
+// errEventNotFound is returned when a previewed event is not in stream_events
+var errEventNotFound = errors.New("event not found")
+
+// capturePrinter collects receipts instead of printing them
+type capturePrinter struct {
+	receipts []*Receipt
+}
+
+// PrintReceipt records the receipt
+func (cp *capturePrinter) PrintReceipt(r *Receipt) error {
+	cp.receipts = append(cp.receipts, r)
+	return nil
+}
+
+// PreviewEvent replays a stored stream event through the registered handlers and
+// renders the receipts they print as a PNG of the paper roll. It returns nil if
+// no handler prints a receipt for the event.
+func (s *Server) PreviewEvent(id int64) ([]byte, error) {
+	if s.eventStore == nil {
+		return nil, fmt.Errorf("stream event store is not initialized")
+	}
+
+	stored, err := s.eventStore.GetEventByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return nil, errEventNotFound
+	}
+
+	frame, err := DecodeGatewayMessage([]byte(stored.RawJSON))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode stored frame: %w", err)
+	}
+	ev, err := DecodeEvent(frame)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode stored event: %w", err)
+	}
+	if ev == nil {
+		return nil, fmt.Errorf("stored frame is not an event")
+	}
+	// Templates see the time the event originally arrived
+	ev.ReceivedAt = stored.ReceivedTimestamp
+
+	capture := &capturePrinter{}
+	s.dispatchTo(ev, capture, true)
+	if len(capture.receipts) == 0 {
+		return nil, nil
+	}
+	return renderReceipts(capture.receipts)
+}
+
+// renderReceipts feeds the ESC/POS commands of receipts to an in-process
+// printer parser, so they go through the same output as real receipts, and
+// renders the paper
+func renderReceipts(receipts []*Receipt) ([]byte, error) {
+	parser := fakeprinter.NewParser()
+	for _, r := range receipts {
+		data, err := encodeReceipt(r)
+		if err != nil {
+			return nil, err
+		}
+		parser.Write(data)
+	}
+	parser.Flush()
+
+	img, err := fakeprinter.Render(parser.Receipts()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render receipt: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode preview: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// HandlePreview serves the receipt a stored event prints as a PNG image
+func (s *Server) HandlePreview(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid event id", http.StatusBadRequest)
+		return
+	}
+
+	img, err := s.PreviewEvent(id)
+	if err != nil {
+		if errors.Is(err, errEventNotFound) {
+			http.Error(w, "Event not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("⚠️  Failed to preview event %d: %v", id, err)
+		http.Error(w, "Failed to render preview", http.StatusInternalServerError)
+		return
+	}
+	if img == nil {
+		http.Error(w, "Event does not print a receipt", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(img)
+}
+
+// runPreview implements the preview command, writing the receipt of a stored
+// event to a PNG file without connecting to Joystick TV or a printer
+func runPreview(args []string) {
+	fs := flag.NewFlagSet("preview", flag.ExitOnError)
+	output := fs.String("o", "", "output file (default preview-<event-id>.png, - for stdout)")
+	dbPath := fs.String("db", "./app.db", "application database")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s preview [-o file.png] [-db app.db] <event-id>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	id, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+	if err != nil {
+		log.Fatalf("❌ Invalid event id: %q", fs.Arg(0))
+	}
+
+	appDB, err := NewAppDatabase(*dbPath)
+	if err != nil {
+		log.Fatalf("❌ Failed to open database: %v", err)
+	}
+	defer appDB.Close()
+
+	server := NewServer("", "", "", "")
+	server.printers = printersFromEnv()
+	server.db = appDB
+	server.eventStore = NewStreamEventStore(appDB.GetDB())
+	if thumbCache, err := NewThumbnailCache(appDB.GetDB(), "./thumbcache"); err == nil {
+		server.thumbCache = thumbCache
+	}
+	registerHandlers(server)
+
+	img, err := server.PreviewEvent(id)
+	if err != nil {
+		log.Fatalf("❌ Failed to preview event %d: %v", id, err)
+	}
+	if img == nil {
+		log.Fatalf("❌ Event %d does not print a receipt", id)
+	}
+
+	if *output == "-" {
+		os.Stdout.Write(img)
+		return
+	}
+	if *output == "" {
+		*output = fmt.Sprintf("preview-%d.png", id)
+	}
+	if err := os.WriteFile(*output, img, 0644); err != nil {
+		log.Fatalf("❌ Failed to write preview: %v", err)
+	}
+	log.Printf("✓ Receipt preview for event %d written to %s", id, *output)
+}
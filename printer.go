@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"tyr.codes/golib/receipt"
+	"tyr.codes/golib/receipt/template"
+)
+
+const (
+	// printerDialTimeout bounds connecting to a network printer
+	printerDialTimeout = 5 * time.Second
+	// printerWriteTimeout bounds sending one receipt
+	printerWriteTimeout = 30 * time.Second
+	// printerStatusTimeout is how long a printer has to answer a status request
+	printerStatusTimeout = 2 * time.Second
+)
+
+// Receipt is a notification together with how it should be printed
+type Receipt struct {
+	Notification *template.StreamerNotification
+	// Printer names the printer to print on; empty means the default printer
+	Printer string
+	// Banner is printed in large type above the notification
+	Banner string
+	// Copies is how many times the receipt is printed; values below 1 print once
+	Copies int
+	// Cut feeds the paper and cuts it after each copy
+	Cut bool
+}
+
+// copies returns how many times the receipt is printed
+func (r *Receipt) copies() int {
+	if r.Copies < 1 {
+		return 1
+	}
+	return r.Copies
+}
+
+// Printer prints receipts. Handlers print through this interface so the
+// destination (print queue, network printer, a capture in tests) can be swapped.
+type Printer interface {
+	PrintReceipt(r *Receipt) error
+}
+
+// DeviceStatus is what a printer reported to the last DLE EOT status requests
+type DeviceStatus struct {
+	CheckedAt time.Time
+	// Supported is false when the printer doesn't answer status requests
+	Supported    bool
+	Offline      bool
+	CoverOpen    bool
+	PaperNearEnd bool
+	PaperOut     bool
+}
+
+// Problem describes why the printer can't print, or returns "" when it can
+func (ds DeviceStatus) Problem() string {
+	switch {
+	case ds.PaperOut:
+		return "paper out"
+	case ds.CoverOpen:
+		return "cover open"
+	case ds.Offline:
+		return "offline"
+	}
+	return ""
+}
+
+// NotReadyError reports that a printer was reached but can't print, such as
+// when it is out of paper
+type NotReadyError struct {
+	Addr    string
+	Problem string
+}
+
+func (e *NotReadyError) Error() string {
+	return fmt.Sprintf("printer %s is not ready: %s", e.Addr, e.Problem)
+}
+
+// errStatusUnsupported is returned when a printer doesn't answer status requests
+var errStatusUnsupported = errors.New("printer does not answer status requests")
+
+// NetworkPrinter prints on an ESC/POS printer reachable over TCP. It keeps one
+// connection open between receipts and reconnects when it fails. A mutex
+// serializes receipts and status probes, so receipts printed from concurrent
+// goroutines never interleave and the printer only ever sees one session.
+type NetworkPrinter struct {
+	addr string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	status DeviceStatus
+	// statusUnsupported is set when the printer didn't answer status requests
+	// on a new connection, and cleared on every new connection
+	statusUnsupported bool
+}
+
+// NewNetworkPrinter creates a printer for the ESC/POS printer at addr (host:port).
+// It connects on the first receipt or probe.
+func NewNetworkPrinter(addr string) *NetworkPrinter {
+	return &NetworkPrinter{addr: addr}
+}
+
+// Addr returns the printer address
+func (np *NetworkPrinter) Addr() string {
+	return np.addr
+}
+
+// PrintReceipt prints every copy of a receipt, with its banner and cut, in
+// one write on the printer session
+func (np *NetworkPrinter) PrintReceipt(r *Receipt) error {
+	data, err := encodeReceipt(r)
+	if err != nil {
+		return err
+	}
+
+	np.mu.Lock()
+	defer np.mu.Unlock()
+
+	if err := np.ready(); err != nil {
+		return err
+	}
+
+	np.conn.SetWriteDeadline(time.Now().Add(printerWriteTimeout))
+	if _, err := np.conn.Write(data); err != nil {
+		np.disconnect()
+		return fmt.Errorf("failed to print receipt: %w", err)
+	}
+	return nil
+}
+
+// ready makes sure the session is connected and the printer can print. The
+// printer's status is requested when a new connection is opened; a reused
+// connection relies on the last status, which Probe keeps current. np.mu must
+// be held.
+func (np *NetworkPrinter) ready() error {
+	if np.conn == nil {
+		if err := np.connect(); err != nil {
+			return err
+		}
+		if err := np.queryStatus(true); err != nil && !errors.Is(err, errStatusUnsupported) {
+			np.disconnect()
+			return &ConnectError{Addr: np.addr, Err: err}
+		}
+	}
+
+	// The session is closed so the next receipt reconnects and checks again,
+	// even when probes are turned off
+	if problem := np.status.Problem(); problem != "" {
+		np.disconnect()
+		return &NotReadyError{Addr: np.addr, Problem: problem}
+	}
+	return nil
+}
+
+// Probe checks the printer's status, connecting if needed. A printer that
+// doesn't answer status requests is checked by connecting to it again. np.mu is
+// taken, so a probe never runs in the middle of a receipt.
+func (np *NetworkPrinter) Probe() (DeviceStatus, error) {
+	np.mu.Lock()
+	defer np.mu.Unlock()
+
+	if np.statusUnsupported {
+		np.disconnect()
+	}
+	fresh := np.conn == nil
+	if fresh {
+		if err := np.connect(); err != nil {
+			return np.status, err
+		}
+	}
+	if err := np.queryStatus(fresh); err != nil && !errors.Is(err, errStatusUnsupported) {
+		np.disconnect()
+		return np.status, &ConnectError{Addr: np.addr, Err: err}
+	}
+	return np.status, nil
+}
+
+// Status returns the result of the last status request
+func (np *NetworkPrinter) Status() DeviceStatus {
+	np.mu.Lock()
+	defer np.mu.Unlock()
+	return np.status
+}
+
+// Close ends the printer session
+func (np *NetworkPrinter) Close() {
+	np.mu.Lock()
+	defer np.mu.Unlock()
+	np.disconnect()
+}
+
+// connect opens the printer session. np.mu must be held.
+func (np *NetworkPrinter) connect() error {
+	conn, err := net.DialTimeout("tcp", np.addr, printerDialTimeout)
+	if err != nil {
+		return &ConnectError{Addr: np.addr, Err: err}
+	}
+	np.conn = conn
+	np.statusUnsupported = false
+	return nil
+}
+
+// disconnect closes the printer session. np.mu must be held.
+func (np *NetworkPrinter) disconnect() {
+	if np.conn != nil {
+		np.conn.Close()
+		np.conn = nil
+	}
+}
+
+// queryStatus asks the printer for its printer status (DLE EOT 1), offline
+// cause (DLE EOT 2) and paper sensor (DLE EOT 4). A printer that doesn't
+// answer in time on a fresh connection is remembered as not supporting status
+// requests until the next connection. On a reused connection the timeout is
+// returned, since a dropped connection or a printer turned off looks the same.
+// np.mu must be held.
+func (np *NetworkPrinter) queryStatus(fresh bool) error {
+	var answers [3]byte
+	for i, n := range []byte{1, 2, 4} {
+		np.conn.SetDeadline(time.Now().Add(printerStatusTimeout))
+		if _, err := np.conn.Write([]byte{0x10, 0x04, n}); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(np.conn, answers[i:i+1]); err != nil {
+			if isTimeout(err) && fresh {
+				np.statusUnsupported = true
+				np.status = DeviceStatus{CheckedAt: time.Now()}
+				np.conn.SetDeadline(time.Time{})
+				return errStatusUnsupported
+			}
+			return err
+		}
+	}
+	np.conn.SetDeadline(time.Time{})
+
+	np.status = DeviceStatus{
+		CheckedAt:    time.Now(),
+		Supported:    true,
+		Offline:      answers[0]&0x08 != 0,
+		CoverOpen:    answers[1]&0x04 != 0,
+		PaperNearEnd: answers[2]&0x0C != 0,
+		PaperOut:     answers[1]&0x20 != 0 || answers[2]&0x60 != 0,
+	}
+	return nil
+}
+
+// encodeReceipt returns the ESC/POS commands for every copy of a receipt. The
+// receipt library renders the notification into memory, so the whole receipt
+// reaches the printer session in one write.
+func encodeReceipt(r *Receipt) ([]byte, error) {
+	if r.Notification == nil {
+		return nil, fmt.Errorf("receipt has no notification")
+	}
+	var notification bytes.Buffer
+	if err := r.Notification.Print(receipt.NewWriterPrinter(&notification)); err != nil {
+		return nil, fmt.Errorf("failed to render notification: %w", err)
+	}
+
+	var buf bytes.Buffer
+	for i := 0; i < r.copies(); i++ {
+		if r.Banner != "" {
+			buf.Write(bannerCommands(r.Banner))
+		}
+		buf.Write(notification.Bytes())
+		if r.Cut {
+			buf.Write(cutCommands())
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// bannerCommands prints text centered in bold double-size type
+func bannerCommands(text string) []byte {
+	b := []byte{
+		0x1B, '@', // initialize
+		0x1B, 'a', 1, // center
+		0x1B, 'E', 1, // bold
+		0x1D, '!', 0x11, // double width and height
+	}
+	b = append(b, text...)
+	b = append(b, '\n',
+		0x1D, '!', 0x00,
+		0x1B, 'E', 0,
+		0x1B, 'a', 0,
+	)
+	return b
+}
+
+// cutCommands feeds the paper past the cutter and makes a partial cut
+func cutCommands() []byte {
+	return []byte{0x1D, 'V', 66, 0}
+}
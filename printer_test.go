@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tyrm/joysticktv-receipt-bot/fakeprinter"
+	"tyr.codes/golib/receipt/template"
+)
+
+// startFakePrinter starts a fake printer and a NetworkPrinter connected to it
+func startFakePrinter(t *testing.T) (*fakeprinter.Server, *NetworkPrinter) {
+	t.Helper()
+
+	fp, err := fakeprinter.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	np := NewNetworkPrinter(fp.Addr())
+	t.Cleanup(func() {
+		np.Close()
+		fp.Close()
+	})
+	return fp, np
+}
+
+// tipReceipt returns a cut tip receipt with a thumbnail
+func tipReceipt() *Receipt {
+	return &Receipt{
+		Notification: &template.StreamerNotification{
+			Header:   "New Tip",
+			Message:  "Spin the wheel",
+			Image:    image.NewGray(image.Rect(0, 0, 64, 64)),
+			Username: "alice",
+		},
+		Cut: true,
+	}
+}
+
+func TestNetworkPrinterPrintsReceipt(t *testing.T) {
+	fp, np := startFakePrinter(t)
+
+	if err := np.PrintReceipt(tipReceipt()); err != nil {
+		t.Fatalf("PrintReceipt: %v", err)
+	}
+
+	receipts, err := fp.WaitForReceipts(1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := receipts[0]
+	text := r.Text()
+	for _, want := range []string{"New Tip", "alice", "Spin the wheel"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("receipt text %q does not contain %q", text, want)
+		}
+	}
+	if !r.Cut {
+		t.Error("receipt was not cut")
+	}
+	if got := len(r.Images()); got != 1 {
+		t.Errorf("receipt has %d images, want 1", got)
+	}
+
+	status := np.Status()
+	if !status.Supported || status.Problem() != "" {
+		t.Errorf("status = %+v, want a supported printer with no problem", status)
+	}
+}
+
+func TestNetworkPrinterNotReady(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		status  fakeprinter.Status
+		problem string
+	}{
+		{"cover open", fakeprinter.Status{CoverOpen: true}, "cover open"},
+		{"paper out", fakeprinter.Status{PaperOut: true}, "paper out"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fp, np := startFakePrinter(t)
+			if err := np.PrintReceipt(tipReceipt()); err != nil {
+				t.Fatalf("PrintReceipt: %v", err)
+			}
+			if _, err := fp.WaitForReceipts(1, 5*time.Second); err != nil {
+				t.Fatal(err)
+			}
+
+			// The open session keeps printing on the last status until a probe
+			fp.SetStatus(tc.status)
+			if err := np.PrintReceipt(tipReceipt()); err != nil {
+				t.Fatalf("PrintReceipt before the probe: %v", err)
+			}
+			if _, err := fp.WaitForReceipts(2, 5*time.Second); err != nil {
+				t.Fatal(err)
+			}
+
+			status, err := np.Probe()
+			if err != nil {
+				t.Fatalf("Probe: %v", err)
+			}
+			if !status.Supported || status.Problem() != tc.problem {
+				t.Errorf("status = %+v, want a supported printer with %q", status, tc.problem)
+			}
+
+			var notReady *NotReadyError
+			if err := np.PrintReceipt(tipReceipt()); !errors.As(err, &notReady) || notReady.Problem != tc.problem {
+				t.Fatalf("PrintReceipt returned %v, want a %q NotReadyError", err, tc.problem)
+			}
+
+			// Printing resumes once the problem is fixed
+			fp.SetStatus(fakeprinter.Status{})
+			if err := np.PrintReceipt(tipReceipt()); err != nil {
+				t.Fatalf("PrintReceipt after the problem was fixed: %v", err)
+			}
+			if _, err := fp.WaitForReceipts(3, 5*time.Second); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestNetworkPrinterWithoutStatus(t *testing.T) {
+	fp, np := startFakePrinter(t)
+	fp.SetStatus(fakeprinter.Status{NoAnswer: true})
+
+	// A printer that ignores DLE EOT still prints
+	for i := 0; i < 2; i++ {
+		if err := np.PrintReceipt(tipReceipt()); err != nil {
+			t.Fatalf("PrintReceipt: %v", err)
+		}
+	}
+	if _, err := fp.WaitForReceipts(2, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := np.Probe()
+	if err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	if status.Supported || status.Problem() != "" {
+		t.Errorf("status = %+v, want an unsupported status with no problem", status)
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PrinterState is the health of a printer as seen by the last print attempt or probe
+type PrinterState string
+
+const (
+	PrinterUnknown  PrinterState = "unknown" // nothing printed or probed yet
+	PrinterOnline   PrinterState = "online"
+	PrinterOffline  PrinterState = "offline"   // the printer could not be reached
+	PrinterNotReady PrinterState = "not ready" // reached, but out of paper or with the cover open
+	PrinterError    PrinterState = "error"     // connected, but printing failed
+)
+
+// DefaultPrinterProbeInterval is how often printers are asked for their status
+const DefaultPrinterProbeInterval = 30 * time.Second
+
+// PrinterConfig describes a named printer
+type PrinterConfig struct {
+	Name string
+	Addr string
+	// Backup names the printer receipts fail over to when this one can't be reached
+	Backup string
+}
+
+// PrinterStatus is a snapshot of a printer's health
+type PrinterStatus struct {
+	Name          string
+	Addr          string
+	Backup        string
+	State         PrinterState
+	Printed       int // receipts printed
+	Failures      int // consecutive failed attempts
+	LastPrintAt   time.Time
+	LastFailureAt time.Time
+	LastError     string
+	Device        DeviceStatus // answers to the last status request
+}
+
+// ConnectError reports that a printer could not be reached, as opposed to
+// failing part way through a receipt
+type ConnectError struct {
+	Addr string
+	Err  error
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("failed to connect to printer %s: %v", e.Addr, e.Err)
+}
+
+func (e *ConnectError) Unwrap() error { return e.Err }
+
+// isConnectError reports whether err means the printer could not be reached
+func isConnectError(err error) bool {
+	var connErr *ConnectError
+	return errors.As(err, &connErr)
+}
+
+// isNotReadyError reports whether err means the printer reported a problem
+func isNotReadyError(err error) bool {
+	var notReady *NotReadyError
+	return errors.As(err, &notReady)
+}
+
+// PrinterSet holds the named printers, routes events to them and tracks their
+// health. It implements Printer by printing on the receipt's printer, failing
+// over to that printer's backup when it can't be reached or isn't ready.
+type PrinterSet struct {
+	printers    map[string]*namedPrinter
+	names       []string // in configured order
+	defaultName string
+	routes      map[string][]string // event name -> printer names
+
+	mu sync.RWMutex // guards the status of every printer
+}
+
+// namedPrinter is a printer of the set together with its health
+type namedPrinter struct {
+	PrinterConfig
+	printer *NetworkPrinter
+	status  PrinterStatus
+}
+
+// NewPrinterSet creates network printers for the configured printers. The
+// printer named "default" is the default one; without it, the first printer is.
+func NewPrinterSet(configs []PrinterConfig) (*PrinterSet, error) {
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no printers configured")
+	}
+
+	ps := &PrinterSet{
+		printers: make(map[string]*namedPrinter),
+		routes:   make(map[string][]string),
+	}
+	for _, cfg := range configs {
+		if cfg.Name == "" {
+			return nil, fmt.Errorf("printer without a name")
+		}
+		if cfg.Addr == "" {
+			return nil, fmt.Errorf("printer %s has no address", cfg.Name)
+		}
+		key := strings.ToLower(cfg.Name)
+		if _, exists := ps.printers[key]; exists {
+			return nil, fmt.Errorf("duplicate printer %s", cfg.Name)
+		}
+		ps.printers[key] = &namedPrinter{
+			PrinterConfig: cfg,
+			printer:       NewNetworkPrinter(cfg.Addr),
+			status: PrinterStatus{
+				Name:   cfg.Name,
+				Addr:   cfg.Addr,
+				Backup: cfg.Backup,
+				State:  PrinterUnknown,
+			},
+		}
+		ps.names = append(ps.names, cfg.Name)
+	}
+
+	for _, np := range ps.printers {
+		if np.Backup == "" {
+			continue
+		}
+		if strings.EqualFold(np.Backup, np.Name) {
+			return nil, fmt.Errorf("printer %s can't be its own backup", np.Name)
+		}
+		if ps.get(np.Backup) == nil {
+			return nil, fmt.Errorf("unknown backup printer %q for %s", np.Backup, np.Name)
+		}
+	}
+
+	ps.defaultName = ps.names[0]
+	if np := ps.get(DefaultPrinterName); np != nil {
+		ps.defaultName = np.Name
+	}
+	return ps, nil
+}
+
+// get returns the printer with the given name, ignoring case, or nil
+func (ps *PrinterSet) get(name string) *namedPrinter {
+	return ps.printers[strings.ToLower(name)]
+}
+
+// Names returns the printer names in configured order
+func (ps *PrinterSet) Names() []string {
+	return append([]string(nil), ps.names...)
+}
+
+// Default returns the name of the printer receipts go to unless routed elsewhere
+func (ps *PrinterSet) Default() string {
+	return ps.defaultName
+}
+
+// SetRoutes sets which printers receipts for each event go to, keyed by
+// stream event type or event kind. Events without a route go to the default printer.
+func (ps *PrinterSet) SetRoutes(routes map[string][]string) error {
+	for event, names := range routes {
+		for _, name := range names {
+			if ps.get(name) == nil {
+				return fmt.Errorf("unknown printer %q in route for %s", name, event)
+			}
+		}
+	}
+
+	ps.routes = make(map[string][]string, len(routes))
+	for event, names := range routes {
+		ps.routes[strings.ToLower(event)] = names
+	}
+	return nil
+}
+
+// Route returns the printers receipts for an event are printed on
+func (ps *PrinterSet) Route(ev *Event) []string {
+	if names, ok := ps.routes[strings.ToLower(eventName(ev))]; ok {
+		return names
+	}
+	return []string{ps.defaultName}
+}
+
+// PrintReceipt prints a receipt on its printer, or the default printer when it
+// names none. When the printer can't be reached or reports a problem such as
+// paper out and has a backup, the receipt is printed on the backup instead.
+func (ps *PrinterSet) PrintReceipt(r *Receipt) error {
+	name := r.Printer
+	if name == "" {
+		name = ps.defaultName
+	}
+	np := ps.get(name)
+	if np == nil {
+		return fmt.Errorf("unknown printer %q", name)
+	}
+
+	err := np.printer.PrintReceipt(r)
+	ps.record(np, err)
+	if err == nil || np.Backup == "" || !(isConnectError(err) || isNotReadyError(err)) {
+		return err
+	}
+
+	backup := ps.get(np.Backup)
+	log.Printf("⚠️  Printer %s is unavailable, failing over to %s: %v", np.Name, backup.Name, err)
+	backupErr := backup.printer.PrintReceipt(r)
+	ps.record(backup, backupErr)
+	if backupErr != nil {
+		return fmt.Errorf("%w (backup %s: %v)", err, backup.Name, backupErr)
+	}
+	return nil
+}
+
+// record updates a printer's health after a print attempt
+func (ps *PrinterSet) record(np *namedPrinter, err error) {
+	device := np.printer.Status()
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	np.status.Device = device
+	if err == nil {
+		np.status.State = PrinterOnline
+		np.status.Printed++
+		np.status.Failures = 0
+		np.status.LastPrintAt = time.Now()
+		return
+	}
+
+	np.status.State = stateForError(err)
+	np.status.Failures++
+	np.status.LastFailureAt = time.Now()
+	np.status.LastError = err.Error()
+}
+
+// stateForError returns the printer state a failed print or probe leaves
+func stateForError(err error) PrinterState {
+	switch {
+	case isConnectError(err):
+		return PrinterOffline
+	case isNotReadyError(err):
+		return PrinterNotReady
+	}
+	return PrinterError
+}
+
+// Monitor probes every printer's status each interval until the context is
+// cancelled, logging when a printer goes offline, reports a problem, or recovers
+func (ps *PrinterSet) Monitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, name := range ps.names {
+			ps.probe(ps.get(name))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe asks a printer for its status and records the result
+func (ps *PrinterSet) probe(np *namedPrinter) {
+	device, err := np.printer.Probe()
+	if err == nil {
+		if problem := device.Problem(); problem != "" {
+			err = &NotReadyError{Addr: np.Addr, Problem: problem}
+		}
+	}
+
+	state := PrinterOnline
+	if err != nil {
+		state = stateForError(err)
+	}
+
+	ps.mu.Lock()
+	previous := np.status.State
+	np.status.State = state
+	np.status.Device = device
+	if err != nil {
+		np.status.LastError = err.Error()
+	}
+	ps.mu.Unlock()
+
+	switch {
+	case state == previous:
+	case err != nil:
+		log.Printf("⚠️  Printer %s: %v", np.Name, err)
+	case previous != PrinterUnknown:
+		log.Printf("✓ Printer %s is back online", np.Name)
+	}
+}
+
+// Status returns a snapshot of every printer's health in configured order
+func (ps *PrinterSet) Status() []PrinterStatus {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	statuses := make([]PrinterStatus, 0, len(ps.names))
+	for _, name := range ps.names {
+		statuses = append(statuses, ps.get(name).status)
+	}
+	return statuses
+}
+
+// ParsePrinters parses a printer list such as
+//
+//	desk=192.168.1.50:9100;camera=192.168.1.51:9100,backup=desk
+//
+// Printers are separated by semicolons. Each is a name and a host:port address,
+// optionally followed by backup= naming the printer to fail over to.
+func ParsePrinters(spec string) ([]PrinterConfig, error) {
+	var configs []PrinterConfig
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		printer, options, _ := strings.Cut(part, ",")
+		name, addr, ok := strings.Cut(printer, "=")
+		name, addr = strings.TrimSpace(name), strings.TrimSpace(addr)
+		if !ok || name == "" || addr == "" {
+			return nil, fmt.Errorf("invalid printer %q, expected name=host:port", printer)
+		}
+		cfg := PrinterConfig{Name: name, Addr: addr}
+
+		for _, opt := range strings.Split(options, ",") {
+			key, value, hasValue := strings.Cut(strings.TrimSpace(opt), "=")
+			switch {
+			case key == "":
+			case key == "backup" && hasValue:
+				cfg.Backup = strings.TrimSpace(value)
+			default:
+				return nil, fmt.Errorf("unknown option %q for printer %s", opt, name)
+			}
+		}
+
+		configs = append(configs, cfg)
+	}
+	return configs, nil
+}
+
+// ParsePrinterRoutes parses event routes such as
+//
+//	tipped=desk;followed=camera;subscribed=desk,camera
+//
+// Routes are separated by semicolons. Each maps a stream event type or event
+// kind to a comma separated list of printers.
+func ParsePrinterRoutes(spec string) (map[string][]string, error) {
+	routes := make(map[string][]string)
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		event, list, ok := strings.Cut(part, "=")
+		event = strings.TrimSpace(event)
+		if !ok || event == "" {
+			return nil, fmt.Errorf("invalid route %q, expected event=printer", part)
+		}
+
+		var names []string
+		for _, name := range strings.Split(list, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("route for %s lists no printers", event)
+		}
+		routes[event] = names
+	}
+	return routes, nil
+}
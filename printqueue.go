@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"time"
+
+	"tyr.codes/golib/receipt/template"
+)
+
+// PrintJobStatus is the lifecycle state of a queued print job
+type PrintJobStatus string
+
+const (
+	PrintJobPending PrintJobStatus = "pending"
+	PrintJobPrinted PrintJobStatus = "printed"
+	PrintJobFailed  PrintJobStatus = "failed"
+)
+
+const (
+	// DefaultPrintMaxAttempts is how often a job is tried before it is marked failed
+	DefaultPrintMaxAttempts = 10
+
+	// printRetryInitialDelay and printRetryMaxDelay bound the backoff between attempts
+	printRetryInitialDelay = 5 * time.Second
+	printRetryMaxDelay     = 5 * time.Minute
+	// printUnavailableMaxDelay bounds the wait for a printer that can't be
+	// reached or isn't ready, so printing resumes soon after it is back
+	printUnavailableMaxDelay = 30 * time.Second
+
+	// printQueueIdlePoll is how often the worker checks the queue when it isn't woken up
+	printQueueIdlePoll = 30 * time.Second
+)
+
+// PrintJob represents a receipt waiting to be printed or already processed
+type PrintJob struct {
+	ID            int64
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	Status        PrintJobStatus
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     *string
+	Header        string
+	Message       string
+	Username      string
+	ImagePNG      []byte
+	Printer       string
+	Banner        string
+	Copies        int
+	Cut           bool
+}
+
+// PrintQueue serializes receipts to a printer through a persistent job table
+type PrintQueue struct {
+	db          *sql.DB
+	printer     Printer
+	maxAttempts int
+	wake        chan struct{}
+}
+
+// NewPrintQueue creates a print queue that sends jobs to printer
+func NewPrintQueue(db *sql.DB, printer Printer) *PrintQueue {
+	return &PrintQueue{
+		db:          db,
+		printer:     printer,
+		maxAttempts: DefaultPrintMaxAttempts,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Enqueue stores a receipt as a pending print job and wakes the worker
+func (pq *PrintQueue) Enqueue(r *Receipt) (int64, error) {
+	notification := r.Notification
+
+	var imagePNG []byte
+	if notification.Image != nil {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, notification.Image); err != nil {
+			return 0, fmt.Errorf("failed to encode receipt image: %w", err)
+		}
+		imagePNG = buf.Bytes()
+	}
+
+	now := time.Now().Unix()
+	result, err := pq.db.Exec(`
+		INSERT INTO print_jobs (created_timestamp, updated_timestamp, status, attempts, next_attempt_timestamp, header, message, username, image_png, printer, banner, copies, cut)
+		VALUES (?, ?, ?, 0, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		now,
+		now,
+		PrintJobPending,
+		now,
+		notification.Header,
+		notification.Message,
+		notification.Username,
+		imagePNG,
+		r.Printer,
+		r.Banner,
+		r.copies(),
+		r.Cut,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert print job: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get print job id: %w", err)
+	}
+
+	pq.notify()
+	return id, nil
+}
+
+// PrintReceipt queues a receipt, implementing Printer
+func (pq *PrintQueue) PrintReceipt(r *Receipt) error {
+	_, err := pq.Enqueue(r)
+	return err
+}
+
+// notify wakes the worker without blocking
+func (pq *PrintQueue) notify() {
+	select {
+	case pq.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run processes pending jobs one at a time until the context is cancelled.
+// Jobs left pending by a previous run are picked up on start.
+func (pq *PrintQueue) Run(ctx context.Context) {
+	if n, err := pq.PendingCount(); err == nil && n > 0 {
+		log.Printf("ℹ️  Resuming print queue with %d pending job(s)", n)
+	}
+
+	for {
+		job, err := pq.nextDueJob()
+		if err != nil {
+			log.Printf("⚠️  Failed to read print queue: %v", err)
+		}
+
+		if job != nil {
+			pq.process(job)
+			continue
+		}
+
+		wait := printQueueIdlePoll
+		if next, err := pq.nextAttemptTime(); err == nil && !next.IsZero() {
+			if until := time.Until(next); until < wait {
+				wait = until
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-pq.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// process makes one attempt at printing a job and records the outcome
+func (pq *PrintQueue) process(job *PrintJob) {
+	err := pq.print(job)
+	attempts := job.Attempts + 1
+
+	if err == nil {
+		if err := pq.markPrinted(job.ID, attempts); err != nil {
+			log.Printf("⚠️  Failed to update print job %d: %v", job.ID, err)
+		}
+		log.Printf("✓ Print job %d printed for %s: %s", job.ID, job.Username, job.Header)
+		return
+	}
+
+	// A printer that can't be reached or isn't ready is no fault of the job: it
+	// keeps its attempts and stays at the head of its printer's queue
+	if isConnectError(err) || isNotReadyError(err) {
+		delay := unavailableRetryDelay(job)
+		if err := pq.markRetry(job.ID, job.Attempts, time.Now().Add(delay), err); err != nil {
+			log.Printf("⚠️  Failed to update print job %d: %v", job.ID, err)
+		}
+		if job.LastError == nil || *job.LastError != err.Error() {
+			log.Printf("⚠️  Print job %d is waiting for the printer, retrying in %s: %v", job.ID, delay, err)
+		}
+		return
+	}
+
+	if attempts >= pq.maxAttempts {
+		if err := pq.markFailed(job.ID, attempts, err); err != nil {
+			log.Printf("⚠️  Failed to update print job %d: %v", job.ID, err)
+		}
+		log.Printf("❌ Print job %d failed after %d attempts: %v", job.ID, attempts, err)
+		return
+	}
+
+	delay := printRetryDelay(attempts)
+	if err := pq.markRetry(job.ID, attempts, time.Now().Add(delay), err); err != nil {
+		log.Printf("⚠️  Failed to update print job %d: %v", job.ID, err)
+	}
+	log.Printf("⚠️  Print job %d attempt %d failed, retrying in %s: %v", job.ID, attempts, delay, err)
+}
+
+// printRetryDelay returns the exponential backoff before the next attempt
+func printRetryDelay(attempts int) time.Duration {
+	delay := printRetryInitialDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= printRetryMaxDelay {
+			return printRetryMaxDelay
+		}
+	}
+	return delay
+}
+
+// unavailableRetryDelay doubles the wait before the job's last attempt, from
+// printRetryInitialDelay up to printUnavailableMaxDelay
+func unavailableRetryDelay(job *PrintJob) time.Duration {
+	delay := 2 * job.NextAttemptAt.Sub(job.UpdatedAt)
+	if delay < printRetryInitialDelay {
+		return printRetryInitialDelay
+	}
+	if delay > printUnavailableMaxDelay {
+		return printUnavailableMaxDelay
+	}
+	return delay
+}
+
+// print sends a job to the printer
+func (pq *PrintQueue) print(job *PrintJob) error {
+	var img image.Image
+	if len(job.ImagePNG) > 0 {
+		decodedImg, err := png.Decode(bytes.NewReader(job.ImagePNG))
+		if err != nil {
+			return fmt.Errorf("failed to decode job image: %w", err)
+		}
+		img = decodedImg
+	}
+
+	return pq.printer.PrintReceipt(&Receipt{
+		Notification: &template.StreamerNotification{
+			Header:   job.Header,
+			Message:  job.Message,
+			Image:    img,
+			Username: job.Username,
+		},
+		Printer: job.Printer,
+		Banner:  job.Banner,
+		Copies:  job.Copies,
+		Cut:     job.Cut,
+	})
+}
+
+// queueHeads selects the oldest pending job of each printer. Jobs print in the
+// order they were queued, so a job waiting for a retry holds back the newer
+// jobs of its printer.
+const queueHeads = `SELECT MIN(id) FROM print_jobs WHERE status = ? GROUP BY printer`
+
+// nextDueJob returns the oldest job at the head of a printer's queue whose
+// next attempt is due, or nil
+func (pq *PrintQueue) nextDueJob() (*PrintJob, error) {
+	job := &PrintJob{}
+	var created, updated, nextAttempt int64
+
+	err := pq.db.QueryRow(`
+		SELECT id, created_timestamp, updated_timestamp, status, attempts, next_attempt_timestamp, last_error, header, message, username, image_png, printer, banner, copies, cut
+		FROM print_jobs
+		WHERE id IN (`+queueHeads+`) AND next_attempt_timestamp <= ?
+		ORDER BY id ASC
+		LIMIT 1
+	`, PrintJobPending, time.Now().Unix()).Scan(
+		&job.ID,
+		&created,
+		&updated,
+		&job.Status,
+		&job.Attempts,
+		&nextAttempt,
+		&job.LastError,
+		&job.Header,
+		&job.Message,
+		&job.Username,
+		&job.ImagePNG,
+		&job.Printer,
+		&job.Banner,
+		&job.Copies,
+		&job.Cut,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query print job: %w", err)
+	}
+
+	job.CreatedAt = time.Unix(created, 0)
+	job.UpdatedAt = time.Unix(updated, 0)
+	job.NextAttemptAt = time.Unix(nextAttempt, 0)
+	return job, nil
+}
+
+// nextAttemptTime returns when the next job at the head of a printer's queue
+// becomes due, or zero if none are pending
+func (pq *PrintQueue) nextAttemptTime() (time.Time, error) {
+	var next sql.NullInt64
+	err := pq.db.QueryRow(`
+		SELECT MIN(next_attempt_timestamp) FROM print_jobs WHERE id IN (`+queueHeads+`)
+	`, PrintJobPending).Scan(&next)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to query next print attempt: %w", err)
+	}
+	if !next.Valid {
+		return time.Time{}, nil
+	}
+	return time.Unix(next.Int64, 0), nil
+}
+
+// PendingCount returns the number of jobs waiting to be printed
+func (pq *PrintQueue) PendingCount() (int, error) {
+	var count int
+	err := pq.db.QueryRow(
+		"SELECT COUNT(*) FROM print_jobs WHERE status = ?",
+		PrintJobPending,
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count pending print jobs: %w", err)
+	}
+	return count, nil
+}
+
+// markPrinted records a successful print
+func (pq *PrintQueue) markPrinted(id int64, attempts int) error {
+	_, err := pq.db.Exec(`
+		UPDATE print_jobs
+		SET status = ?, attempts = ?, updated_timestamp = ?, last_error = NULL
+		WHERE id = ?
+	`, PrintJobPrinted, attempts, time.Now().Unix(), id)
+	return err
+}
+
+// markRetry records a failed attempt and schedules the next one
+func (pq *PrintQueue) markRetry(id int64, attempts int, next time.Time, printErr error) error {
+	_, err := pq.db.Exec(`
+		UPDATE print_jobs
+		SET attempts = ?, updated_timestamp = ?, next_attempt_timestamp = ?, last_error = ?
+		WHERE id = ?
+	`, attempts, time.Now().Unix(), next.Unix(), printErr.Error(), id)
+	return err
+}
+
+// markFailed records that a job ran out of attempts
+func (pq *PrintQueue) markFailed(id int64, attempts int, printErr error) error {
+	_, err := pq.db.Exec(`
+		UPDATE print_jobs
+		SET status = ?, attempts = ?, updated_timestamp = ?, last_error = ?
+		WHERE id = ?
+	`, PrintJobFailed, attempts, time.Now().Unix(), printErr.Error(), id)
+	return err
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"tyr.codes/golib/receipt/template"
+)
+
+// failingPrinter fails every receipt with err and records the headers it was sent
+type failingPrinter struct {
+	err     error
+	headers []string
+}
+
+// PrintReceipt records the receipt's header and returns fp.err
+func (fp *failingPrinter) PrintReceipt(r *Receipt) error {
+	fp.headers = append(fp.headers, r.Notification.Header)
+	return fp.err
+}
+
+// newTestPrintQueue returns a print queue on a fresh database with two queued jobs
+func newTestPrintQueue(t *testing.T, printer Printer) *PrintQueue {
+	t.Helper()
+
+	appDB, err := NewAppDatabase(t.TempDir() + "/app.db")
+	if err != nil {
+		t.Fatalf("NewAppDatabase: %v", err)
+	}
+	t.Cleanup(func() { appDB.Close() })
+
+	pq := NewPrintQueue(appDB.GetDB(), printer)
+	for _, header := range []string{"first", "second"} {
+		if _, err := pq.Enqueue(&Receipt{Notification: &template.StreamerNotification{Header: header}}); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+	return pq
+}
+
+func TestPrintQueueKeepsOrderDuringBackoff(t *testing.T) {
+	printer := &failingPrinter{err: errors.New("failed to decode job image")}
+	pq := newTestPrintQueue(t, printer)
+
+	job, err := pq.nextDueJob()
+	if err != nil || job == nil || job.Header != "first" {
+		t.Fatalf("nextDueJob = %+v, %v, want the first job", job, err)
+	}
+	pq.process(job)
+
+	// The first job waits for its retry, and the second waits behind it
+	if job, err := pq.nextDueJob(); err != nil || job != nil {
+		t.Errorf("nextDueJob during backoff = %+v, %v, want nothing due", job, err)
+	}
+	if next, err := pq.nextAttemptTime(); err != nil || next.IsZero() {
+		t.Errorf("nextAttemptTime = %v, %v, want the first job's retry", next, err)
+	}
+}
+
+func TestPrintQueueUnavailablePrinterKeepsAttempts(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+	}{
+		{"unreachable", &ConnectError{Addr: "127.0.0.1:9100", Err: errors.New("connection refused")}},
+		{"paper out", &NotReadyError{Addr: "127.0.0.1:9100", Problem: "paper out"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			printer := &failingPrinter{err: tc.err}
+			pq := newTestPrintQueue(t, printer)
+			pq.maxAttempts = 2
+
+			job, err := pq.nextDueJob()
+			if err != nil || job == nil {
+				t.Fatalf("nextDueJob = %+v, %v", job, err)
+			}
+			for i := 0; i < 3; i++ {
+				pq.process(job)
+				if _, err := pq.db.Exec(`UPDATE print_jobs SET next_attempt_timestamp = 0 WHERE id = ?`, job.ID); err != nil {
+					t.Fatalf("reset next attempt: %v", err)
+				}
+				if job, err = pq.nextDueJob(); err != nil || job == nil {
+					t.Fatalf("nextDueJob after attempt %d = %+v, %v", i+1, job, err)
+				}
+			}
+
+			if job.Header != "first" || job.Status != PrintJobPending || job.Attempts != 0 {
+				t.Errorf("job = %s %s with %d attempts, want the first job pending with 0 attempts", job.Header, job.Status, job.Attempts)
+			}
+			for _, header := range printer.headers {
+				if header != "first" {
+					t.Errorf("printer was sent %q while the first job was waiting", header)
+				}
+			}
+		})
+	}
+}
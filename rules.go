@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	receipttemplate "tyr.codes/golib/receipt/template"
+)
+
+// Rule actions
+const (
+	RuleActionPrint = "print"
+	RuleActionSkip  = "skip"
+)
+
+// DefaultPrinterName names the printer configured with RECEIPT_ADDR, which
+// receipts go to unless routed elsewhere
+const DefaultPrinterName = "default"
+
+// rulesWatchInterval is how often the rules file is checked for changes
+const rulesWatchInterval = 2 * time.Second
+
+// RuleSet is a parsed rules file. Rules are evaluated in order and the first
+// match decides what happens to an event; events no rule matches go to the
+// built-in handlers.
+type RuleSet struct {
+	Rules []*Rule `json:"rules"`
+}
+
+// Rule maps events matching its conditions to an action
+type Rule struct {
+	Name   string    `json:"name"`
+	Match  RuleMatch `json:"match"`
+	Action string    `json:"action"`
+
+	// Print options
+	Header  string     `json:"header,omitempty"`  // template, defaults per event type
+	Message string     `json:"message,omitempty"` // template, defaults to the event text
+	Printer stringList `json:"printer,omitempty"` // one or more printers, defaults to the event's route
+	Banner  string     `json:"banner,omitempty"`
+	Copies  int        `json:"copies,omitempty"`
+	Cut     bool       `json:"cut,omitempty"`
+
+	// Skip options
+	Reason string `json:"reason,omitempty"`
+
+	header  *template.Template
+	message *template.Template
+}
+
+// RuleMatch holds the conditions of a rule; all set conditions must hold
+type RuleMatch struct {
+	Event       stringList `json:"event,omitempty"`         // event kind or stream event type
+	TipMenuItem stringList `json:"tip_menu_item,omitempty"` // case-insensitive
+	MinAmount   *int       `json:"min_amount,omitempty"`
+	MaxAmount   *int       `json:"max_amount,omitempty"`
+	Username    stringList `json:"username,omitempty"` // case-insensitive
+	Text        string     `json:"text,omitempty"`     // regular expression on the event or chat text
+
+	text *regexp.Regexp
+}
+
+// stringList accepts either a single string or a list of strings
+type stringList []string
+
+// UnmarshalJSON implements json.Unmarshaler
+func (sl *stringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*sl = stringList{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("expected a string or a list of strings")
+	}
+	*sl = list
+	return nil
+}
+
+// containsFold reports whether s equals one of the list entries, ignoring case
+func (sl stringList) containsFold(s string) bool {
+	for _, v := range sl {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadRules reads and validates a rules file. printers lists the printer
+// names rules may print to.
+func LoadRules(path string, printers []string) (*RuleSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file: %w", err)
+	}
+
+	var rs RuleSet
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rs); err != nil {
+		return nil, fmt.Errorf("failed to parse rules file %s: %w", path, err)
+	}
+
+	for i, rule := range rs.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("#%d", i+1)
+		}
+		if err := rule.compile(printers); err != nil {
+			return nil, fmt.Errorf("rule %s: %w", rule.Name, err)
+		}
+	}
+	return &rs, nil
+}
+
+// compile validates a rule and prepares its regular expression and templates
+func (r *Rule) compile(printers []string) error {
+	switch r.Action {
+	case RuleActionPrint:
+	case RuleActionSkip:
+		if r.Header != "" || r.Message != "" || len(r.Printer) > 0 || r.Banner != "" || r.Copies != 0 || r.Cut {
+			return fmt.Errorf("print options are not allowed with action %q", r.Action)
+		}
+	case "":
+		return fmt.Errorf("missing action")
+	default:
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+
+	if r.Copies < 0 {
+		return fmt.Errorf("invalid copies %d", r.Copies)
+	}
+	for _, name := range r.Printer {
+		if !stringList(printers).containsFold(name) {
+			return fmt.Errorf("unknown printer %q", name)
+		}
+	}
+
+	if r.Match.Text != "" {
+		re, err := regexp.Compile(r.Match.Text)
+		if err != nil {
+			return fmt.Errorf("invalid text pattern: %w", err)
+		}
+		r.Match.text = re
+	}
+
+	var err error
+	if r.Header != "" {
+		if r.header, err = parseTemplate("header", r.Header); err != nil {
+			return err
+		}
+	}
+	if r.Message != "" {
+		if r.message, err = parseTemplate("message", r.Message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Matches reports whether an event satisfies every condition of the rule
+func (r *Rule) Matches(ev *Event) bool {
+	m := r.Match
+	if len(m.Event) > 0 && !m.Event.containsFold(ev.Kind) && !(ev.Type != "" && m.Event.containsFold(ev.Type)) {
+		return false
+	}
+	if len(m.TipMenuItem) > 0 && (ev.Tipped == nil || !m.TipMenuItem.containsFold(ev.Tipped.TipMenuItem)) {
+		return false
+	}
+	if m.MinAmount != nil && ev.Amount() < *m.MinAmount {
+		return false
+	}
+	if m.MaxAmount != nil && ev.Amount() > *m.MaxAmount {
+		return false
+	}
+	if len(m.Username) > 0 && !m.Username.containsFold(ev.User()) {
+		return false
+	}
+	if m.text != nil && !m.text.MatchString(ev.Text()) {
+		return false
+	}
+	return true
+}
+
+// Match returns the first rule matching the event, or nil
+func (rs *RuleSet) Match(ev *Event) *Rule {
+	for _, rule := range rs.Rules {
+		if rule.Matches(ev) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Receipt builds the receipt a print rule produces for an event, without a
+// printer; the rule's printers are set by Handle
+func (r *Rule) Receipt(hc *HandlerContext) (*Receipt, error) {
+	data := hc.TemplateData()
+
+	header := defaultHeader(hc.Event)
+	if r.header != nil {
+		var err error
+		if header, err = renderTemplate(r.header, data); err != nil {
+			return nil, err
+		}
+	}
+
+	message := data.Text
+	if message == "" {
+		message = data.TipMenuItem
+	}
+	if r.message != nil {
+		var err error
+		if message, err = renderTemplate(r.message, data); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Receipt{
+		Notification: &receipttemplate.StreamerNotification{
+			Header:   header,
+			Message:  message,
+			Image:    hc.Thumbnail(data.Username),
+			Username: data.Username,
+		},
+		Banner: r.Banner,
+		Copies: r.Copies,
+		Cut:    r.Cut,
+	}, nil
+}
+
+// defaultHeader returns the header of the built-in receipt for an event type
+func defaultHeader(ev *Event) string {
+	switch ev.Type {
+	case "tipped":
+		return defaultTipHeader
+	case "followed":
+		return defaultFollowHeader
+	case "subscribed":
+		return defaultSubscribeHeader
+	}
+	if ev.Kind == KindChatMessage {
+		return "Chat Message"
+	}
+	return eventName(ev)
+}
+
+// rulesHandler applies the rules file before the built-in handlers. An event
+// matching a rule is printed or skipped as the rule says and not passed on.
+type rulesHandler struct {
+	path     string
+	printers []string
+	rules    atomic.Pointer[RuleSet]
+
+	mu      sync.Mutex // serializes reloads
+	modTime time.Time  // of the rules file when it was last read
+}
+
+// newRulesHandler loads the rules file at path
+func newRulesHandler(path string, printers []string) (*rulesHandler, error) {
+	h := &rulesHandler{path: path, printers: printers}
+	if err := h.Reload(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Reload reads the rules file again. On error the previous rules stay active.
+func (h *rulesHandler) Reload() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if info, err := os.Stat(h.path); err == nil {
+		h.modTime = info.ModTime()
+	}
+
+	rs, err := LoadRules(h.path, h.printers)
+	if err != nil {
+		return err
+	}
+	h.rules.Store(rs)
+	return nil
+}
+
+// Watch reloads the rules whenever the file's modification time changes,
+// until the context is cancelled
+func (h *rulesHandler) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(h.path)
+		if err != nil || !h.changed(info.ModTime()) {
+			continue
+		}
+
+		if err := h.Reload(); err != nil {
+			log.Printf("❌ Failed to reload rules, keeping the previous rules: %v", err)
+			continue
+		}
+		log.Printf("✓ Reloaded %d rule(s) from %s", len(h.Rules().Rules), h.path)
+	}
+}
+
+// changed reports whether modTime differs from the rules file's when it was last read
+func (h *rulesHandler) changed(modTime time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !modTime.Equal(h.modTime)
+}
+
+// Rules returns the active rule set
+func (h *rulesHandler) Rules() *RuleSet {
+	return h.rules.Load()
+}
+
+// Name identifies the handler
+func (h *rulesHandler) Name() string { return "rules" }
+
+// Handles returns the event keys consumed by the handler
+func (h *rulesHandler) Handles() []string { return []string{EventKeyAll} }
+
+// Handle applies the first matching rule to the event
+func (h *rulesHandler) Handle(hc *HandlerContext) error {
+	rule := h.Rules().Match(hc.Event)
+	if rule == nil {
+		return nil
+	}
+
+	if rule.Action == RuleActionSkip {
+		reason := rule.Reason
+		if reason == "" {
+			reason = "skipped by rule " + rule.Name
+		}
+		hc.Logf("ℹ️  Rule %s skipped %s event from %s", rule.Name, eventName(hc.Event), hc.Username())
+		hc.Skip(reason)
+		hc.Stop()
+		return nil
+	}
+
+	if !hc.RequirePrinter() {
+		hc.Stop()
+		return nil
+	}
+
+	// Until the rule's receipt is queued the event stays with the built-in
+	// handlers, so a rule that fails to render or print doesn't lose it
+	receipt, err := rule.Receipt(hc)
+	if err != nil {
+		return fmt.Errorf("rule %s: %w", rule.Name, err)
+	}
+	// Without printers of its own the rule's receipt is routed like the built-in receipts
+	targets := []string(rule.Printer)
+	if len(targets) == 0 {
+		targets = []string{""}
+	}
+	var errs []error
+	for _, name := range targets {
+		routed := *receipt
+		routed.Printer = name
+		if err := hc.PrintReceipt(&routed); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == len(targets) {
+		return fmt.Errorf("rule %s: failed to queue notification: %w", rule.Name, errors.Join(errs...))
+	}
+	for _, err := range errs {
+		hc.Logf("⚠️  Rule %s failed to queue notification: %v", rule.Name, err)
+	}
+	hc.Stop()
+
+	hc.Logf("✓ Rule %s notification queued for %s: %s", rule.Name, receipt.Notification.Username, receipt.Notification.Header)
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tyrm/joysticktv-receipt-bot/fakejoystick"
+)
+
+// decodeFrame decodes a fake gateway frame into an event
+func decodeFrame(t *testing.T, frame fakejoystick.Frame) *Event {
+	t.Helper()
+
+	data, err := json.Marshal(frame)
+	if err != nil {
+		t.Fatalf("marshal frame: %v", err)
+	}
+	msg, err := DecodeGatewayMessage(data)
+	if err != nil {
+		t.Fatalf("DecodeGatewayMessage: %v", err)
+	}
+	ev, err := DecodeEvent(msg)
+	if err != nil || ev == nil {
+		t.Fatalf("DecodeEvent = %v, %v", ev, err)
+	}
+	return ev
+}
+
+// writeRules writes a rules file and returns its path
+func writeRules(t *testing.T, rules string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(rules), 0644); err != nil {
+		t.Fatalf("write rules: %v", err)
+	}
+	return path
+}
+
+func TestRuleMatches(t *testing.T) {
+	tip := decodeFrame(t, fakejoystick.Tipped("Alice", 100, "Spin the Wheel", "go go"))
+	follow := decodeFrame(t, fakejoystick.Followed("bob"))
+	chat := decodeFrame(t, fakejoystick.ChatMessage(fakejoystick.Author{Slug: "carol", Username: "carol"}, "!hydrate now"))
+
+	min50, max99, max100 := 50, 99, 100
+	for _, tc := range []struct {
+		name  string
+		match RuleMatch
+		ev    *Event
+		want  bool
+	}{
+		{"no conditions", RuleMatch{}, follow, true},
+		{"event type", RuleMatch{Event: stringList{"Tipped"}}, tip, true},
+		{"event kind", RuleMatch{Event: stringList{"streamevent"}}, follow, true},
+		{"other event", RuleMatch{Event: stringList{"tipped", "subscribed"}}, follow, false},
+		{"tip menu item in any case", RuleMatch{TipMenuItem: stringList{"spin the wheel"}}, tip, true},
+		{"tip menu item on a follow", RuleMatch{TipMenuItem: stringList{"spin the wheel"}}, follow, false},
+		{"amount in range", RuleMatch{MinAmount: &min50, MaxAmount: &max100}, tip, true},
+		{"amount above max", RuleMatch{MaxAmount: &max99}, tip, false},
+		{"min amount on a follow", RuleMatch{MinAmount: &min50}, follow, false},
+		{"username in any case", RuleMatch{Username: stringList{"alice"}}, tip, true},
+		{"other username", RuleMatch{Username: stringList{"bob"}}, tip, false},
+		{"text pattern", RuleMatch{Text: `^!hydrate\b`}, chat, true},
+		{"text pattern without a match", RuleMatch{Text: `^!hydrate\b`}, tip, false},
+		{"every condition", RuleMatch{Event: stringList{"tipped"}, Username: stringList{"ALICE"}, MinAmount: &min50, Text: "go"}, tip, true},
+		{"one condition fails", RuleMatch{Event: stringList{"tipped"}, Username: stringList{"bob"}, MinAmount: &min50}, tip, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rule := &Rule{Name: tc.name, Match: tc.match, Action: RuleActionSkip}
+			if err := rule.compile(nil); err != nil {
+				t.Fatalf("compile: %v", err)
+			}
+			if got := rule.Matches(tc.ev); got != tc.want {
+				t.Errorf("Matches = %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadRulesErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		rules string
+		want  string
+	}{
+		{"not json", `{"rules": [`, "failed to parse rules file"},
+		{"unknown field", `{"rules": [{"action": "skip", "colour": "red"}]}`, `unknown field "colour"`},
+		{"missing action", `{"rules": [{"name": "tips"}]}`, "rule tips: missing action"},
+		{"unknown action", `{"rules": [{"action": "shout"}]}`, `rule #1: unknown action "shout"`},
+		{"print options on skip", `{"rules": [{"action": "skip", "banner": "BIG"}]}`, `print options are not allowed with action "skip"`},
+		{"negative copies", `{"rules": [{"action": "print", "copies": -1}]}`, "invalid copies -1"},
+		{"unknown printer", `{"rules": [{"action": "print", "printer": ["default", "bar"]}]}`, `unknown printer "bar"`},
+		{"invalid text pattern", `{"rules": [{"action": "skip", "match": {"text": "("}}]}`, "invalid text pattern"},
+		{"invalid template", `{"rules": [{"action": "print", "header": "{{.Username"}]}`, "header"},
+		{"event not a string", `{"rules": [{"action": "skip", "match": {"event": 5}}]}`, "expected a string or a list of strings"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := LoadRules(writeRules(t, tc.rules), []string{DefaultPrinterName})
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("LoadRules error = %v, want one containing %q", err, tc.want)
+			}
+		})
+	}
+
+	if _, err := LoadRules(filepath.Join(t.TempDir(), "missing.json"), nil); err == nil {
+		t.Error("LoadRules accepted a missing file")
+	}
+}
+
+func TestStringListDecoding(t *testing.T) {
+	for _, tc := range []struct {
+		json    string
+		want    stringList
+		wantErr bool
+	}{
+		{`"tipped"`, stringList{"tipped"}, false},
+		{`["tipped", "followed"]`, stringList{"tipped", "followed"}, false},
+		{`[]`, stringList{}, false},
+		{`""`, stringList{""}, false},
+		{`5`, nil, true},
+		{`[1, 2]`, nil, true},
+		{`{"event": "tipped"}`, nil, true},
+	} {
+		var got stringList
+		err := json.Unmarshal([]byte(tc.json), &got)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: error = %v, want error %t", tc.json, err, tc.wantErr)
+			continue
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s: decoded %q, want %q", tc.json, got, tc.want)
+		}
+	}
+}
+
+func TestRulesHandlerStopsOnlyAfterPrinting(t *testing.T) {
+	h, err := newRulesHandler(writeRules(t, `{"rules": [
+		{"name": "big tips", "match": {"event": "tipped", "min_amount": 100}, "action": "print", "banner": "BIG"},
+		{"name": "quiet", "match": {"username": "bob"}, "action": "skip"}
+	]}`), []string{DefaultPrinterName})
+	if err != nil {
+		t.Fatalf("newRulesHandler: %v", err)
+	}
+
+	handle := func(ev *Event, printer Printer) *printOutcome {
+		outcome := &printOutcome{}
+		hc := &HandlerContext{Event: ev, server: &Server{}, printer: printer, outcome: outcome, preview: true}
+		h.Handle(hc)
+		return outcome
+	}
+	tip := decodeFrame(t, fakejoystick.Tipped("alice", 100, "", ""))
+
+	if outcome := handle(tip, &failingPrinter{}); !outcome.stopped || outcome.status != PrintOutcomePrinted {
+		t.Errorf("printed rule: outcome = %+v, want printed and stopped", outcome)
+	}
+	if outcome := handle(tip, &failingPrinter{err: errors.New("disk full")}); outcome.stopped {
+		t.Error("a rule that failed to print stopped the built-in handlers")
+	}
+	if outcome := handle(decodeFrame(t, fakejoystick.Followed("bob")), nil); !outcome.stopped || outcome.status != PrintOutcomeSkipped {
+		t.Errorf("skip rule: outcome = %+v, want skipped and stopped", outcome)
+	}
+	if outcome := handle(decodeFrame(t, fakejoystick.Followed("carol")), &failingPrinter{}); outcome.stopped || outcome.status != "" {
+		t.Errorf("no matching rule: outcome = %+v, want untouched", outcome)
+	}
+}
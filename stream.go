@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// subscriberBuffer is how many events a live feed subscriber may fall
+	// behind before it is disconnected
+	subscriberBuffer = 256
+	// maxReplayEvents bounds how many stored events a resuming client is sent
+	maxReplayEvents = 1000
+	// streamKeepaliveInterval is how often an idle event stream sends a comment,
+	// so proxies don't close it
+	streamKeepaliveInterval = 30 * time.Second
+)
+
+// LiveEvent is a gateway event as sent on the live feed
+type LiveEvent struct {
+	// ID is the stream_events row of a stream event; chat and presence
+	// events aren't numbered
+	ID         int64     `json:"id,omitempty"`
+	Kind       string    `json:"kind"`
+	Type       string    `json:"type,omitempty"`
+	User       string    `json:"user,omitempty"`
+	Text       string    `json:"text,omitempty"`
+	CreatedAt  string    `json:"created_at,omitempty"`
+	ReceivedAt time.Time `json:"received_at"`
+	// Metadata is the decoded metadata object of a stream event
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	RawJSON  string                 `json:"raw_json"`
+}
+
+// newLiveEvent converts a decoded event for the live feed
+func newLiveEvent(ev *Event) LiveEvent {
+	le := LiveEvent{
+		ID:         ev.StoredID,
+		Kind:       ev.Kind,
+		Type:       ev.Type,
+		User:       ev.User(),
+		Text:       ev.Text(),
+		CreatedAt:  ev.CreatedAt(),
+		ReceivedAt: ev.ReceivedAt.UTC(),
+		RawJSON:    string(ev.Frame.Raw),
+	}
+	if ev.Metadata != nil {
+		le.Metadata = ev.Metadata.Fields
+	}
+	return le
+}
+
+// EventBroadcaster fans decoded events out to live feed subscribers. Publishing
+// never blocks: a subscriber that falls too far behind is dropped and has to
+// reconnect, resuming with Last-Event-ID.
+type EventBroadcaster struct {
+	mu          sync.Mutex
+	subscribers map[chan *Event]struct{}
+}
+
+// NewEventBroadcaster creates a broadcaster without subscribers
+func NewEventBroadcaster() *EventBroadcaster {
+	return &EventBroadcaster{subscribers: make(map[chan *Event]struct{})}
+}
+
+// Subscribe returns a channel receiving every published event. The channel is
+// closed by Unsubscribe, or when the subscriber falls behind.
+func (eb *EventBroadcaster) Subscribe() chan *Event {
+	ch := make(chan *Event, subscriberBuffer)
+	eb.mu.Lock()
+	eb.subscribers[ch] = struct{}{}
+	eb.mu.Unlock()
+	return ch
+}
+
+// Unsubscribe stops delivering events to a subscriber and closes its channel
+func (eb *EventBroadcaster) Unsubscribe(ch chan *Event) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	if _, ok := eb.subscribers[ch]; ok {
+		delete(eb.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Publish sends an event to every subscriber
+func (eb *EventBroadcaster) Publish(ev *Event) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	for ch := range eb.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			log.Printf("⚠️  Event stream subscriber fell behind, disconnecting it")
+			delete(eb.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribers returns how many subscribers are connected
+func (eb *EventBroadcaster) Subscribers() int {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	return len(eb.subscribers)
+}
+
+// eventFilter selects live feed events by the type query parameter. A filter
+// is an event type such as "tipped", a kind such as "ChatMessage" or a handler
+// event key such as "StreamEvent:followed". An empty filter matches every event.
+type eventFilter map[string]bool
+
+// parseEventFilter reads the type parameter, repeated or comma separated
+func parseEventFilter(values []string) eventFilter {
+	filter := make(eventFilter)
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				filter[t] = true
+			}
+		}
+	}
+	return filter
+}
+
+// Match reports whether an event passes the filter
+func (f eventFilter) Match(ev *Event) bool {
+	if len(f) == 0 {
+		return true
+	}
+	if ev.Kind == KindStreamEvent && f[ev.Type] {
+		return true
+	}
+	for _, key := range ev.Keys() {
+		if f[key] {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleEventStream serves decoded gateway events as Server-Sent Events. Each
+// event is a JSON LiveEvent; stream events carry their stream_events id as the
+// SSE id. A client resuming with Last-Event-ID (or the last_event_id query
+// parameter) is first sent the stream events stored after that id. Chat and
+// presence events aren't stored with an id, so they aren't replayed.
+func (s *Server) HandleEventStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	filter := parseEventFilter(r.URL.Query()["type"])
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	var resumeAfter int64
+	if lastID != "" {
+		id, err := strconv.ParseInt(lastID, 10, 64)
+		if err != nil || id < 0 {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		resumeAfter = id
+	}
+
+	// Subscribe before replaying, so no event falls between the replay and
+	// the live feed
+	events := s.broadcaster.Subscribe()
+	defer s.broadcaster.Unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	lastSent := resumeAfter
+	if lastID != "" && s.eventStore != nil {
+		stored, err := s.eventStore.GetEventsAfter(resumeAfter, maxReplayEvents)
+		if err != nil {
+			log.Printf("⚠️  Failed to replay events after %d: %v", resumeAfter, err)
+		}
+		for i := range stored {
+			ev, err := storedEventToEvent(&stored[i])
+			if err != nil {
+				log.Printf("⚠️  Failed to replay event %d: %v", stored[i].ID, err)
+				continue
+			}
+			if filter.Match(ev) {
+				if err := writeStreamEvent(w, ev); err != nil {
+					return
+				}
+			}
+			lastSent = stored[i].ID
+		}
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(streamKeepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case ev, ok := <-events:
+			if !ok {
+				// Dropped for falling behind; the client reconnects and resumes
+				return
+			}
+			// Skip stream events the replay already sent
+			if ev.StoredID != 0 && ev.StoredID <= lastSent {
+				continue
+			}
+			if !filter.Match(ev) {
+				continue
+			}
+			if err := writeStreamEvent(w, ev); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeStreamEvent writes one event in the SSE format
+func writeStreamEvent(w http.ResponseWriter, ev *Event) error {
+	data, err := json.Marshal(newLiveEvent(ev))
+	if err != nil {
+		log.Printf("⚠️  Failed to encode live event: %v", err)
+		return nil
+	}
+	if ev.StoredID != 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", ev.StoredID); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+	return err
+}
+
+// storedEventToEvent decodes the frame of a stored stream event
+func storedEventToEvent(se *StreamEvent) (*Event, error) {
+	frame, err := DecodeGatewayMessage([]byte(se.RawJSON))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode stored frame: %w", err)
+	}
+	ev, err := DecodeEvent(frame)
+	if ev == nil {
+		if err == nil {
+			err = fmt.Errorf("stored frame is not an event")
+		}
+		return nil, err
+	}
+	ev.ReceivedAt = se.ReceivedTimestamp
+	ev.StoredID = se.ID
+	return ev, nil
+}
@@ -2,18 +2,25 @@ package main
 
 import (
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 )
 
+// errDuplicateEvent is returned when an event with the same message key is already stored
+var errDuplicateEvent = errors.New("duplicate stream event")
+
 // StreamEvent represents an event stored in the database
 type StreamEvent struct {
-	ID                   int64
-	ReceivedTimestamp    time.Time
-	EventType            string
+	ID                     int64
+	ReceivedTimestamp      time.Time
+	EventType              string
 	UserWhoPerformedAction *string
-	RawJSON              string
+	RawJSON                string
+	MessageKey             *string // gateway message id or creation time, unique
+	PrintStatus            *string // printed, skipped or failed; nil until handlers ran
+	PrintReason            *string // why the event was skipped or failed
 }
 
 // StreamEventStore handles storing events in the database
@@ -28,114 +35,84 @@ func NewStreamEventStore(db *sql.DB) *StreamEventStore {
 	}
 }
 
-// ExtractEventInfo extracts event type and user information from a WebSocket message
-// Returns event type, user (if available), and whether extraction was successful
-func ExtractEventInfo(msg map[string]interface{}) (string, *string, bool) {
-	var eventType string
-	var user *string
-
-	// Try to extract event information from nested message structure
-	if message, ok := msg["message"].(map[string]interface{}); ok {
-		// Check for "type" field inside message (specific event type like "tipped", "ChatMessage", "enter_stream")
-		if typeField, ok := message["type"].(string); ok && typeField != "" {
-			eventType = typeField
-		}
-
-		// Try to extract user information from author object
-		if author, ok := message["author"].(map[string]interface{}); ok {
-			// Prefer slug, fallback to username
-			if slug, ok := author["slug"].(string); ok && slug != "" {
-				user = &slug
-			} else if username, ok := author["username"].(string); ok && username != "" {
-				user = &username
-			}
-		}
-
-		// If no user from author, try to extract from metadata JSON (for StreamEvents)
-		if user == nil {
-			if metadata, ok := message["metadata"].(string); ok && metadata != "" {
-				var metadataObj map[string]interface{}
-				if err := json.Unmarshal([]byte(metadata), &metadataObj); err == nil {
-					if who, ok := metadataObj["who"].(string); ok && who != "" {
-						user = &who
-					}
-				}
-			}
-		}
+// StoreEvent stores a stream event in the database and returns its row id
+// Only stores StreamEvent messages; other event types are handled separately.
+// Returns errDuplicateEvent if an event with the same message key was stored before.
+func (ses *StreamEventStore) StoreEvent(ev *Event) (int64, error) {
+	// Only store StreamEvent messages
+	if ev.Kind != KindStreamEvent {
+		return 0, nil // Silently skip non-StreamEvent messages
 	}
 
-	// If event type was found, return it
-	if eventType != "" {
-		return eventType, user, true
+	if ev.Type == "" {
+		return 0, fmt.Errorf("unable to extract event information from message")
 	}
 
-	// Check for top-level "type" field (welcome, ping, subscription confirmations)
-	if typeField, ok := msg["type"].(string); ok {
-		return typeField, nil, true
+	var user *string
+	if who := ev.User(); who != "" {
+		user = &who
 	}
 
-	// Check for "event" field as fallback (alternative message structure)
-	if event, ok := msg["event"].(string); ok {
-		return event, user, true
+	var messageKey *string
+	if key := ev.MessageKey(); key != "" {
+		messageKey = &key
 	}
 
-	return "", nil, false
-}
+	// Store in database, keeping the frame exactly as received. The unique
+	// index on message_key turns a redelivered message into a no-op.
+	timestamp := time.Now().Unix()
 
-// IsStreamEvent checks if a message is a StreamEvent
-func IsStreamEvent(msg map[string]interface{}) bool {
-	if message, ok := msg["message"].(map[string]interface{}); ok {
-		if event, ok := message["event"].(string); ok {
-			return event == "StreamEvent"
-		}
-	}
-	return false
-}
+	result, err := ses.db.Exec(`
+		INSERT INTO stream_events (received_timestamp, event_type, user_who_performed_action, raw_json, message_key)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (message_key) DO NOTHING
+	`,
+		timestamp,
+		ev.Type,
+		user,
+		string(ev.Frame.Raw),
+		messageKey,
+	)
 
-// StoreEvent stores a stream event in the database
-// Only stores StreamEvent messages; other event types are handled separately
-func (ses *StreamEventStore) StoreEvent(msg map[string]interface{}) error {
-	// Only store StreamEvent messages
-	if !IsStreamEvent(msg) {
-		return nil // Silently skip non-StreamEvent messages
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert stream event: %w", err)
 	}
 
-	// Extract event type and user information
-	eventType, user, ok := ExtractEventInfo(msg)
-	if !ok {
-		return fmt.Errorf("unable to extract event information from message")
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return 0, errDuplicateEvent
 	}
 
-	// Convert message to JSON for storage
-	rawJSON, err := json.Marshal(msg)
+	id, err := result.LastInsertId()
 	if err != nil {
-		return fmt.Errorf("failed to marshal message to JSON: %w", err)
+		return 0, fmt.Errorf("failed to get stream event id: %w", err)
 	}
 
-	// Store in database
-	timestamp := time.Now().Unix()
+	return id, nil
+}
 
-	_, err = ses.db.Exec(`
-		INSERT INTO stream_events (received_timestamp, event_type, user_who_performed_action, raw_json)
-		VALUES (?, ?, ?, ?)
-	`,
-		timestamp,
-		eventType,
-		user,
-		string(rawJSON),
-	)
+// SetPrintOutcome records whether a receipt was printed for an event and why not
+func (ses *StreamEventStore) SetPrintOutcome(id int64, status, reason string) error {
+	var reasonValue *string
+	if reason != "" {
+		reasonValue = &reason
+	}
 
+	_, err := ses.db.Exec(`
+		UPDATE stream_events SET print_status = ?, print_reason = ? WHERE id = ?
+	`, status, reasonValue, id)
 	if err != nil {
-		return fmt.Errorf("failed to insert stream event: %w", err)
+		return fmt.Errorf("failed to update print outcome of event %d: %w", id, err)
 	}
-
 	return nil
 }
 
+// streamEventColumns are the columns read into a StreamEvent, in scan order
+const streamEventColumns = `id, received_timestamp, event_type, user_who_performed_action, raw_json, message_key, print_status, print_reason`
+
 // GetEventsByType retrieves events of a specific type from the database
 func (ses *StreamEventStore) GetEventsByType(eventType string, limit int) ([]StreamEvent, error) {
 	rows, err := ses.db.Query(`
-		SELECT id, received_timestamp, event_type, user_who_performed_action, raw_json
+		SELECT `+streamEventColumns+`
 		FROM stream_events
 		WHERE event_type = ?
 		ORDER BY received_timestamp DESC
@@ -144,87 +121,145 @@ func (ses *StreamEventStore) GetEventsByType(eventType string, limit int) ([]Str
 	if err != nil {
 		return nil, fmt.Errorf("failed to query events: %w", err)
 	}
-	defer rows.Close()
-
-	var events []StreamEvent
-	for rows.Next() {
-		var event StreamEvent
-		var timestamp int64
-
-		err := rows.Scan(
-			&event.ID,
-			&timestamp,
-			&event.EventType,
-			&event.UserWhoPerformedAction,
-			&event.RawJSON,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan event: %w", err)
-		}
+	return scanStreamEvents(rows)
+}
 
-		event.ReceivedTimestamp = time.Unix(timestamp, 0)
-		events = append(events, event)
+// GetEventsByUser retrieves events performed by a specific user, matching the name in any case
+func (ses *StreamEventStore) GetEventsByUser(user string, limit int) ([]StreamEvent, error) {
+	rows, err := ses.db.Query(`
+		SELECT `+streamEventColumns+`
+		FROM stream_events
+		WHERE user_who_performed_action = ? COLLATE NOCASE
+		ORDER BY received_timestamp DESC
+		LIMIT ?
+	`, user, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events: %w", err)
 	}
+	return scanStreamEvents(rows)
+}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating events: %w", err)
+// GetRecentEvents retrieves the most recent events
+func (ses *StreamEventStore) GetRecentEvents(limit int) ([]StreamEvent, error) {
+	rows, err := ses.db.Query(`
+		SELECT `+streamEventColumns+`
+		FROM stream_events
+		ORDER BY received_timestamp DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events: %w", err)
 	}
-
-	return events, nil
+	return scanStreamEvents(rows)
 }
 
-// GetEventsByUser retrieves events performed by a specific user
-func (ses *StreamEventStore) GetEventsByUser(user string, limit int) ([]StreamEvent, error) {
+// GetEventsAfter retrieves the events stored after an id, oldest first
+func (ses *StreamEventStore) GetEventsAfter(id int64, limit int) ([]StreamEvent, error) {
 	rows, err := ses.db.Query(`
-		SELECT id, received_timestamp, event_type, user_who_performed_action, raw_json
+		SELECT `+streamEventColumns+`
 		FROM stream_events
-		WHERE user_who_performed_action = ?
-		ORDER BY received_timestamp DESC
+		WHERE id > ?
+		ORDER BY id
 		LIMIT ?
-	`, user, limit)
+	`, id, limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query events: %w", err)
 	}
-	defer rows.Close()
+	return scanStreamEvents(rows)
+}
 
-	var events []StreamEvent
-	for rows.Next() {
-		var event StreamEvent
-		var timestamp int64
+// EventQuery filters stream events for QueryEvents. Zero fields don't filter.
+type EventQuery struct {
+	Types []string // any of these event types
+	User  string
+	Since time.Time // received at or after
+	Until time.Time // received before
+	// Text is searched case-insensitively in the message text and metadata
+	Text string
+	// BeforeID only returns events older than this id, the cursor of the next page
+	BeforeID int64
+	Limit    int
+}
 
-		err := rows.Scan(
-			&event.ID,
-			&timestamp,
-			&event.EventType,
-			&event.UserWhoPerformedAction,
-			&event.RawJSON,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan event: %w", err)
-		}
+// QueryEvents retrieves the events matching a query, newest (highest id) first
+func (ses *StreamEventStore) QueryEvents(q EventQuery) ([]StreamEvent, error) {
+	var where []string
+	var args []interface{}
 
-		event.ReceivedTimestamp = time.Unix(timestamp, 0)
-		events = append(events, event)
+	if len(q.Types) > 0 {
+		where = append(where, "event_type IN (?"+strings.Repeat(", ?", len(q.Types)-1)+")")
+		for _, t := range q.Types {
+			args = append(args, t)
+		}
+	}
+	if q.User != "" {
+		where = append(where, "user_who_performed_action = ? COLLATE NOCASE")
+		args = append(args, q.User)
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "received_timestamp >= ?")
+		args = append(args, q.Since.Unix())
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "received_timestamp < ?")
+		args = append(args, q.Until.Unix())
+	}
+	if q.Text != "" {
+		where = append(where, `(instr(lower(COALESCE(json_extract(raw_json, '$.message.text'), '')), lower(?)) > 0
+			OR instr(lower(COALESCE(json_extract(raw_json, '$.message.metadata'), '')), lower(?)) > 0)`)
+		args = append(args, q.Text, q.Text)
+	}
+	if q.BeforeID > 0 {
+		where = append(where, "id < ?")
+		args = append(args, q.BeforeID)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating events: %w", err)
+	query := `SELECT ` + streamEventColumns + ` FROM stream_events`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
 	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, q.Limit)
 
-	return events, nil
+	rows, err := ses.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events: %w", err)
+	}
+	return scanStreamEvents(rows)
 }
 
-// GetRecentEvents retrieves the most recent events
-func (ses *StreamEventStore) GetRecentEvents(limit int) ([]StreamEvent, error) {
-	rows, err := ses.db.Query(`
-		SELECT id, received_timestamp, event_type, user_who_performed_action, raw_json
+// GetEventByID retrieves a single event, returning nil if it does not exist
+func (ses *StreamEventStore) GetEventByID(id int64) (*StreamEvent, error) {
+	var event StreamEvent
+	var timestamp int64
+
+	err := ses.db.QueryRow(`
+		SELECT `+streamEventColumns+`
 		FROM stream_events
-		ORDER BY received_timestamp DESC
-		LIMIT ?
-	`, limit)
+		WHERE id = ?
+	`, id).Scan(
+		&event.ID,
+		&timestamp,
+		&event.EventType,
+		&event.UserWhoPerformedAction,
+		&event.RawJSON,
+		&event.MessageKey,
+		&event.PrintStatus,
+		&event.PrintReason,
+	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query events: %w", err)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query event: %w", err)
 	}
+
+	event.ReceivedTimestamp = time.Unix(timestamp, 0)
+	return &event, nil
+}
+
+// scanStreamEvents reads stream event rows and closes them
+func scanStreamEvents(rows *sql.Rows) ([]StreamEvent, error) {
 	defer rows.Close()
 
 	var events []StreamEvent
@@ -238,6 +273,9 @@ func (ses *StreamEventStore) GetRecentEvents(limit int) ([]StreamEvent, error) {
 			&event.EventType,
 			&event.UserWhoPerformedAction,
 			&event.RawJSON,
+			&event.MessageKey,
+			&event.PrintStatus,
+			&event.PrintReason,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan event: %w", err)
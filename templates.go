@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+)
+
+// TemplateData is what receipt header and message templates can refer to
+type TemplateData struct {
+	Event       string // stream event type, or the kind for chat and presence
+	Kind        string // StreamEvent, ChatMessage or UserPresence
+	Username    string
+	Amount      int    // tokens tipped
+	TipMenuItem string // tip menu item of a tip, if any
+	Months      int    // months subscribed, for resubscriptions
+	Text        string // event or chat message text
+	CreatedAt   time.Time
+	ReceivedAt  time.Time
+	// Metadata holds every field of a stream event's metadata
+	Metadata map[string]interface{}
+}
+
+// eventName returns the stream event type, or the kind for chat and presence events
+func eventName(ev *Event) string {
+	if ev.Type != "" {
+		return ev.Type
+	}
+	return ev.Kind
+}
+
+// newTemplateData collects the template data of an event
+func newTemplateData(ev *Event, username string) TemplateData {
+	data := TemplateData{
+		Event:      eventName(ev),
+		Kind:       ev.Kind,
+		Username:   username,
+		Amount:     ev.Amount(),
+		Text:       ev.Text(),
+		ReceivedAt: ev.ReceivedAt,
+		Metadata:   map[string]interface{}{},
+	}
+	if t, err := time.Parse(time.RFC3339, ev.CreatedAt()); err == nil {
+		data.CreatedAt = t
+	}
+	if ev.Tipped != nil {
+		data.TipMenuItem = ev.Tipped.TipMenuItem
+	}
+	if ev.Subscribed != nil {
+		data.Months = ev.Subscribed.Months
+	}
+	if ev.Metadata != nil {
+		for k, v := range ev.Metadata.Fields {
+			data.Metadata[k] = v
+		}
+	}
+	return data
+}
+
+// templateFuncs are the helper functions available to receipt templates
+var templateFuncs = template.FuncMap{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"title": titleCase,
+	"trim":  strings.TrimSpace,
+	// default returns value, or fallback when value is empty: {{.Text | default "Welcome!"}}
+	"default": func(fallback, value string) string {
+		if strings.TrimSpace(value) == "" {
+			return fallback
+		}
+		return value
+	},
+	// truncate shortens s to at most n characters, ending in "..." when cut
+	"truncate": func(n int, s string) string {
+		runes := []rune(s)
+		if len(runes) <= n {
+			return s
+		}
+		if n <= 3 {
+			return string(runes[:n])
+		}
+		return string(runes[:n-3]) + "..."
+	},
+	"number": formatNumber,
+	// plural picks the singular or plural word for n: {{plural .Months "month" "months"}}
+	"plural": func(n int, singular, plural string) string {
+		if n == 1 {
+			return singular
+		}
+		return plural
+	},
+	// tokens formats an amount such as "1 token" or "1,500 tokens"
+	"tokens": func(n int) string {
+		if n == 1 {
+			return "1 token"
+		}
+		return formatNumber(n) + " tokens"
+	},
+	// date formats a time with a Go layout: {{date "Jan 2 15:04" .CreatedAt}}
+	"date": func(layout string, t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Local().Format(layout)
+	},
+	"repeat": func(n int, s string) string {
+		if n < 0 {
+			n = 0
+		}
+		return strings.Repeat(s, n)
+	},
+}
+
+// titleCase upper-cases the first letter of every word
+func titleCase(s string) string {
+	runes := []rune(s)
+	start := true
+	for i, r := range runes {
+		if unicode.IsSpace(r) {
+			start = true
+			continue
+		}
+		if start {
+			runes[i] = unicode.ToUpper(r)
+			start = false
+		}
+	}
+	return string(runes)
+}
+
+// formatNumber formats an integer with thousands separators
+func formatNumber(n int) string {
+	digits := strconv.Itoa(n)
+	sign := ""
+	if n < 0 {
+		sign, digits = "-", digits[1:]
+	}
+
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(d)
+	}
+	return sign + b.String()
+}
+
+// parseTemplate parses a receipt template with the helper functions. Only the
+// syntax is checked: whether a template renders depends on the event, such as
+// the metadata fields it has, so render errors are reported when an event arrives.
+// A metadata field the event doesn't have is an error rather than "<no value>"
+// on the receipt.
+func parseTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// renderTemplate executes a template into a string
+func renderTemplate(tmpl *template.Template, data TemplateData) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render %s: %w", tmpl.Name(), err)
+	}
+	return buf.String(), nil
+}
+
+// NotificationTemplate holds the header and message templates of a receipt
+type NotificationTemplate struct {
+	header  *template.Template
+	message *template.Template
+}
+
+// ParseNotificationTemplate parses the header and message templates of a receipt
+func ParseNotificationTemplate(header, message string) (*NotificationTemplate, error) {
+	h, err := parseTemplate("header", header)
+	if err != nil {
+		return nil, err
+	}
+	m, err := parseTemplate("message", message)
+	if err != nil {
+		return nil, err
+	}
+	return &NotificationTemplate{header: h, message: m}, nil
+}
+
+// Render returns the header and message for an event
+func (nt *NotificationTemplate) Render(data TemplateData) (header, message string, err error) {
+	if header, err = renderTemplate(nt.header, data); err != nil {
+		return "", "", err
+	}
+	if message, err = renderTemplate(nt.message, data); err != nil {
+		return "", "", err
+	}
+	return header, message, nil
+}
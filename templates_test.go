@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// render parses and renders a template, failing the test on parse errors
+func render(t *testing.T, text string, data TemplateData) (string, error) {
+	t.Helper()
+
+	tmpl, err := parseTemplate("test", text)
+	if err != nil {
+		t.Fatalf("parseTemplate(%q): %v", text, err)
+	}
+	return renderTemplate(tmpl, data)
+}
+
+func TestTemplateHelpers(t *testing.T) {
+	data := TemplateData{
+		Username: "alice",
+		Amount:   1500,
+		Text:     "Spin the wheel please",
+		Metadata: map[string]interface{}{"who": "alice"},
+	}
+
+	for _, tc := range []struct {
+		text string
+		data TemplateData
+		want string
+	}{
+		// default takes the fallback first, so the value can be piped in
+		{`{{.Text | default "Welcome!"}}`, data, "Spin the wheel please"},
+		{`{{.Text | default "Welcome!"}}`, TemplateData{}, "Welcome!"},
+		{`{{.Text | default "Welcome!"}}`, TemplateData{Text: "  "}, "Welcome!"},
+		{`{{default "fallback" "value"}}`, data, "value"},
+		{`{{default "fallback" ""}}`, data, "fallback"},
+
+		{`{{.Text | truncate 10}}`, data, "Spin th..."},
+		{`{{.Text | truncate 21}}`, data, "Spin the wheel please"},
+		{`{{.Text | truncate 3}}`, data, "Spi"},
+		{`{{.Text | truncate 0}}`, data, ""},
+		{`{{truncate 4 "héllo"}}`, data, "h..."},
+		{`{{truncate 5 "héllo"}}`, data, "héllo"},
+
+		{`{{number 0}}`, data, "0"},
+		{`{{number 999}}`, data, "999"},
+		{`{{number 1000}}`, data, "1,000"},
+		{`{{number 1234567}}`, data, "1,234,567"},
+		{`{{number -1234}}`, data, "-1,234"},
+
+		{`{{tokens 1}}`, data, "1 token"},
+		{`{{tokens 0}}`, data, "0 tokens"},
+		{`{{tokens .Amount}}`, data, "1,500 tokens"},
+
+		{`{{.Metadata.who}}`, data, "alice"},
+		{`{{with index .Metadata "missing"}}{{.}}{{end}}`, data, ""},
+	} {
+		got, err := render(t, tc.text, tc.data)
+		if err != nil {
+			t.Errorf("%s: %v", tc.text, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s = %q, want %q", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestTemplateMissingKey(t *testing.T) {
+	data := TemplateData{Metadata: map[string]interface{}{"who": "alice"}}
+
+	got, err := render(t, `{{.Metadata.what}}`, data)
+	if err == nil {
+		t.Fatalf("rendering a missing metadata field = %q, want an error", got)
+	}
+	if !strings.Contains(err.Error(), "what") {
+		t.Errorf("error %q doesn't name the missing field", err)
+	}
+}
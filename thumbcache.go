@@ -114,6 +114,34 @@ func (tc *ThumbnailCache) NeedsRefresh(username string) (bool, error) {
 	return age > 5*time.Minute, nil
 }
 
+// ThumbnailStats summarizes the cached thumbnails
+type ThumbnailStats struct {
+	Count          int
+	TotalSize      int64
+	LastDownloadAt time.Time // zero when nothing is cached
+}
+
+// Stats returns the number and total size of the cached thumbnails
+func (tc *ThumbnailCache) Stats() (ThumbnailStats, error) {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+
+	var stats ThumbnailStats
+	var lastDownload sql.NullInt64
+	err := tc.db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(file_size), 0), MAX(download_timestamp)
+		FROM thumbnails
+	`).Scan(&stats.Count, &stats.TotalSize, &lastDownload)
+	if err != nil {
+		return stats, fmt.Errorf("database query error: %w", err)
+	}
+
+	if lastDownload.Valid {
+		stats.LastDownloadAt = time.Unix(lastDownload.Int64, 0)
+	}
+	return stats, nil
+}
+
 // getSubdirectory extracts the first letter of the username for directory organization
 // Returns lowercase first letter, or "other" for edge cases
 func (tc *ThumbnailCache) getSubdirectory(username string) string {
@@ -1,117 +1,64 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
-	"image"
-	"image/png"
-	"log"
-	"os"
-	"tyr.codes/golib/receipt"
-	"tyr.codes/golib/receipt/template"
+	"fmt"
 )
 
-// HandleTippedEvent processes a tipped stream event and prints a receipt notification
-func (s *Server) HandleTippedEvent(msg map[string]interface{}) {
-	// Ensure we have a printer address configured
-	if s.printerAddr == "" {
-		log.Printf("ℹ️  No printer address configured, skipping tip notification")
-		return
-	}
+// Default receipt templates of the tip handler
+const (
+	defaultTipHeader  = "New Tip"
+	defaultTipMessage = `{{.Text | default .TipMenuItem}}`
+)
 
-	// Extract the message object
-	message, ok := msg["message"].(map[string]interface{})
-	if !ok {
-		return
-	}
+// tipHandler prints a receipt for tips made through the tip menu, with the
+// amount deciding whether it prints and which tier applies
+type tipHandler struct {
+	policy TipPolicy
+	tmpl   *NotificationTemplate
+}
 
-	// Extract metadata JSON string
-	metadataStr, ok := message["metadata"].(string)
-	if !ok || metadataStr == "" {
-		return
-	}
+// Name is "tip", the name DISABLED_HANDLERS turns tip menu receipts off with
+func (h *tipHandler) Name() string { return "tip" }
 
-	// Parse metadata JSON
-	var metadata map[string]interface{}
-	if err := json.Unmarshal([]byte(metadataStr), &metadata); err != nil {
-		log.Printf("⚠️  Failed to parse tip metadata: %v", err)
-		return
-	}
+// Handles returns the tipped event, which the tip handler shares with the
+// plain tip handler; it only prints the tips made through the tip menu
+func (h *tipHandler) Handles() []string { return []string{StreamEventKey("tipped")} }
 
+// Handle processes a tipped stream event and queues a receipt notification
+func (h *tipHandler) Handle(hc *HandlerContext) error {
 	// Require tip_menu_item to be populated
-	tipMenuItem, ok := metadata["tip_menu_item"].(string)
-	if !ok || tipMenuItem == "" {
-		return // No tip menu item, skip notification
+	tip := hc.Event.Tipped
+	if tip == nil || tip.TipMenuItem == "" {
+		return nil // No tip menu item, printed by the plain tip handler
 	}
-
-	// Extract text field from message (the full tip message)
-	messageText, ok := message["text"].(string)
-	if !ok || messageText == "" {
-		// Fallback to tip menu item if text is not available
-		messageText = tipMenuItem
+	if !hc.RequirePrinter() {
+		return nil
 	}
 
-	// Extract username from author field first
-	var username string
-	var img image.Image
-	if author, ok := message["author"].(map[string]interface{}); ok {
-		if slug, ok := author["slug"].(string); ok && slug != "" {
-			username = slug
-		} else if usr, ok := author["username"].(string); ok && usr != "" {
-			username = usr
-		}
+	username := hc.Username()
 
-		// Try to get the user's cached profile thumbnail
-		if username != "" && s.thumbCache != nil {
-			if thumbInfo, err := s.thumbCache.GetThumbnailInfo(username); err == nil && thumbInfo != nil && thumbInfo.FileExtension != "" {
-				thumbPath := s.thumbCache.GetFilePath(username, thumbInfo.FileExtension)
-				if file, err := os.Open(thumbPath); err == nil {
-					defer file.Close()
-					if decodedImg, err := png.Decode(file); err == nil {
-						img = decodedImg
-					}
-				}
-			}
-		}
+	if !h.policy.Prints(tip.HowMuch) {
+		hc.Logf("ℹ️  Tip of %d tokens from %s is below the minimum of %d, skipping notification", tip.HowMuch, username, h.policy.MinAmount)
+		hc.Skip(fmt.Sprintf("below the minimum of %d tokens", h.policy.MinAmount))
+		return nil
 	}
 
-	// Fallback to metadata username if author field didn't work
-	if username == "" {
-		username, _ = metadata["who"].(string)
-	}
-	if username == "" {
-		username = "Anonymous"
+	// Create the notification, dress it up for the tier the amount reaches and queue it
+	notification, err := hc.Notification(h.tmpl)
+	if err != nil {
+		return fmt.Errorf("failed to render tip notification: %w", err)
 	}
-
-	// If we don't have a cached thumbnail, decode the embedded joysticktv.png
-	if img == nil {
-		if decodedImg, err := png.Decode(bytes.NewReader(joysticktv)); err == nil {
-			img = decodedImg
-		} else {
-			log.Printf("⚠️  Failed to decode embedded image: %v", err)
+	receipt := &Receipt{Notification: notification}
+	if tier := h.policy.TierFor(tip.HowMuch); tier != nil {
+		if err := tier.Apply(receipt, hc.TemplateData()); err != nil {
+			return fmt.Errorf("failed to render tip tier: %w", err)
 		}
 	}
 
-	// Connect to printer, print notification, then disconnect
-	printer := receipt.NewPrinter(s.printerAddr)
-	if err := printer.Connect(); err != nil {
-		log.Printf("❌ Failed to connect to printer: %v", err)
-		return
-	}
-	defer printer.Disconnect()
-
-	// Create and print the notification
-	notification := &template.StreamerNotification{
-		Header:   "New Tip",
-		Message:  messageText,
-		Image:    img,
-		Username: username,
-	}
-
-	if err := notification.Print(printer); err != nil {
-		log.Printf("⚠️  Failed to print tip notification: %v", err)
-		return
+	if err := hc.PrintReceipt(receipt); err != nil {
+		return fmt.Errorf("failed to queue tip notification: %w", err)
 	}
 
-	log.Printf("✓ Tip notification printed for %s: %s", username, messageText)
+	hc.Logf("✓ Tip notification queued for %s: %s", username, notification.Message)
+	return nil
 }
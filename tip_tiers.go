@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// TipTier changes how tips of at least MinAmount tokens are printed
+type TipTier struct {
+	MinAmount int
+	Header    string // template replacing the receipt header when set
+	Banner    string // printed in large type above the receipt
+	Copies    int    // number of receipts; 0 prints one
+	Cut       bool   // cut the paper after each receipt
+
+	header *template.Template
+}
+
+// TipPolicy decides from the amount whether a tip prints and which tier applies
+type TipPolicy struct {
+	// MinAmount is the smallest tip, in tokens, that prints a receipt
+	MinAmount int
+	// Tiers is sorted by MinAmount; the highest tier a tip reaches applies
+	Tiers []TipTier
+}
+
+// Prints reports whether a tip of amount tokens prints a receipt
+func (tp TipPolicy) Prints(amount int) bool {
+	return amount >= tp.MinAmount
+}
+
+// TierFor returns the highest tier a tip of amount tokens reaches, or nil
+func (tp TipPolicy) TierFor(amount int) *TipTier {
+	var tier *TipTier
+	for i := range tp.Tiers {
+		if amount >= tp.Tiers[i].MinAmount {
+			tier = &tp.Tiers[i]
+		}
+	}
+	return tier
+}
+
+// Apply sets the tier's header and print options on a receipt
+func (t *TipTier) Apply(r *Receipt, data TemplateData) error {
+	if t.header != nil {
+		header, err := renderTemplate(t.header, data)
+		if err != nil {
+			return err
+		}
+		r.Notification.Header = header
+	}
+	r.Banner = t.Banner
+	r.Copies = t.Copies
+	r.Cut = t.Cut
+	return nil
+}
+
+// ParseTipTiers parses a tier list such as
+//
+//	100:header=Big Tip,cut;1000:header=Huge Tip,banner=THANK YOU,copies=2,cut
+//
+// Tiers are separated by semicolons. Each starts with the minimum amount,
+// followed by comma separated options: header= (a template), banner=,
+// copies= and cut. Tiers may be given in any order, but each amount only once.
+func ParseTipTiers(spec string) ([]TipTier, error) {
+	var tiers []TipTier
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		amount, options, _ := strings.Cut(part, ":")
+		tier := TipTier{}
+		minAmount, err := strconv.Atoi(strings.TrimSpace(amount))
+		if err != nil || minAmount < 0 {
+			return nil, fmt.Errorf("invalid tier amount %q", amount)
+		}
+		tier.MinAmount = minAmount
+
+		for _, opt := range strings.Split(options, ",") {
+			key, value, hasValue := strings.Cut(strings.TrimSpace(opt), "=")
+			switch {
+			case key == "":
+			case key == "header" && hasValue:
+				tier.Header = value
+				if tier.header, err = parseTemplate("header", value); err != nil {
+					return nil, fmt.Errorf("tier %d: %w", minAmount, err)
+				}
+			case key == "banner" && hasValue:
+				tier.Banner = value
+			case key == "copies" && hasValue:
+				copies, err := strconv.Atoi(value)
+				if err != nil || copies < 1 {
+					return nil, fmt.Errorf("invalid copies %q in tier %d", value, minAmount)
+				}
+				tier.Copies = copies
+			case key == "cut" && !hasValue:
+				tier.Cut = true
+			default:
+				return nil, fmt.Errorf("unknown option %q in tier %d", opt, minAmount)
+			}
+		}
+
+		tiers = append(tiers, tier)
+	}
+
+	sort.SliceStable(tiers, func(i, j int) bool {
+		return tiers[i].MinAmount < tiers[j].MinAmount
+	})
+	for i := 1; i < len(tiers); i++ {
+		if tiers[i].MinAmount == tiers[i-1].MinAmount {
+			return nil, fmt.Errorf("tier %d is defined twice", tiers[i].MinAmount)
+		}
+	}
+	return tiers, nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"tyr.codes/golib/receipt/template"
+)
+
+// tierSummary is the parsed part of a tier that tests compare
+type tierSummary struct {
+	MinAmount int
+	Header    string
+	Banner    string
+	Copies    int
+	Cut       bool
+}
+
+// summarizeTiers returns the parsed part of each tier
+func summarizeTiers(tiers []TipTier) []tierSummary {
+	var summaries []tierSummary
+	for _, tier := range tiers {
+		summaries = append(summaries, tierSummary{tier.MinAmount, tier.Header, tier.Banner, tier.Copies, tier.Cut})
+	}
+	return summaries
+}
+
+func TestParseTipTiers(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		spec string
+		want []tierSummary
+	}{
+		{"empty", "", nil},
+		{"only separators", " ; ;", nil},
+		{"one tier", "100:header=Big Tip,cut", []tierSummary{{100, "Big Tip", "", 0, true}}},
+		{"no options", "50", []tierSummary{{50, "", "", 0, false}}},
+		{"sorted by amount", "1000:banner=THANK YOU;100:cut;500:copies=2", []tierSummary{
+			{100, "", "", 0, true},
+			{500, "", "", 2, false},
+			{1000, "", "THANK YOU", 0, false},
+		}},
+		{"spaces around parts", " 100 : copies=3 , cut ; ", []tierSummary{{100, "", "", 3, true}}},
+		{"every option", "1000:header=Huge Tip,banner=THANK YOU,copies=2,cut", []tierSummary{{1000, "Huge Tip", "THANK YOU", 2, true}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tiers, err := ParseTipTiers(tc.spec)
+			if err != nil {
+				t.Fatalf("ParseTipTiers(%q): %v", tc.spec, err)
+			}
+			got := summarizeTiers(tiers)
+			if len(got) != len(tc.want) {
+				t.Fatalf("ParseTipTiers(%q) = %+v, want %+v", tc.spec, got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("tier %d = %+v, want %+v", i, got[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseTipTiersErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		spec string
+		want string
+	}{
+		{"amount not a number", "lots:cut", `invalid tier amount "lots"`},
+		{"negative amount", "-5:cut", `invalid tier amount "-5"`},
+		{"zero copies", "100:copies=0", `invalid copies "0" in tier 100`},
+		{"copies not a number", "100:copies=two", `invalid copies "two" in tier 100`},
+		{"unknown option", "100:colour=red", `unknown option "colour=red" in tier 100`},
+		{"cut with a value", "100:cut=yes", `unknown option "cut=yes" in tier 100`},
+		{"header without a value", "100:header", `unknown option "header" in tier 100`},
+		{"invalid header template", "100:header={{.Username", "tier 100"},
+		{"same amount twice", "100:cut;500:copies=2;100:banner=BIG", "tier 100 is defined twice"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tiers, err := ParseTipTiers(tc.spec)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("ParseTipTiers(%q) = %+v, %v, want an error containing %q", tc.spec, tiers, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestTipPolicyTierFor(t *testing.T) {
+	tiers, err := ParseTipTiers("1000:copies=2;100:cut")
+	if err != nil {
+		t.Fatalf("ParseTipTiers: %v", err)
+	}
+	policy := TipPolicy{MinAmount: 10, Tiers: tiers}
+
+	for _, tc := range []struct {
+		amount int
+		prints bool
+		tier   int // MinAmount of the tier that applies, 0 for none
+	}{
+		{0, false, 0},
+		{9, false, 0},
+		{10, true, 0},
+		{99, true, 0},
+		{100, true, 100},
+		{999, true, 100},
+		{1000, true, 1000},
+		{50000, true, 1000},
+	} {
+		if got := policy.Prints(tc.amount); got != tc.prints {
+			t.Errorf("Prints(%d) = %t, want %t", tc.amount, got, tc.prints)
+		}
+		got := 0
+		if tier := policy.TierFor(tc.amount); tier != nil {
+			got = tier.MinAmount
+		}
+		if got != tc.tier {
+			t.Errorf("TierFor(%d) = tier %d, want %d", tc.amount, got, tc.tier)
+		}
+	}
+}
+
+func TestTipTierApply(t *testing.T) {
+	tiers, err := ParseTipTiers("100:header=Thanks {{.Username}},banner=BIG,copies=3,cut")
+	if err != nil {
+		t.Fatalf("ParseTipTiers: %v", err)
+	}
+
+	r := &Receipt{Notification: &template.StreamerNotification{Header: "New Tip"}}
+	if err := tiers[0].Apply(r, TemplateData{Username: "alice"}); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if r.Notification.Header != "Thanks alice" || r.Banner != "BIG" || r.Copies != 3 || !r.Cut {
+		t.Errorf("receipt = header %q, banner %q, %d copies, cut %t, want Thanks alice, BIG, 3 copies, cut",
+			r.Notification.Header, r.Banner, r.Copies, r.Cut)
+	}
+
+	// Without a header the handler's header stays
+	r = &Receipt{Notification: &template.StreamerNotification{Header: "New Tip"}}
+	if err := (&TipTier{Copies: 2}).Apply(r, TemplateData{}); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if r.Notification.Header != "New Tip" || r.Copies != 2 {
+		t.Errorf("receipt = header %q, %d copies, want New Tip, 2 copies", r.Notification.Header, r.Copies)
+	}
+}
+
+func TestTipPolicyFromEnv(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		minAmount string
+		tiers     string
+		wantMin   int
+		copies    []int // receipts printed by each tier, in amount order
+	}{
+		{"unset", "", "", 0, nil},
+		{"min amount only", "25", "", 25, nil},
+		{"tiers in any order", "10", "500:copies=2;100:cut;1000:copies=5", 10, []int{1, 2, 5}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("TEST_TIP_MIN_AMOUNT", tc.minAmount)
+			t.Setenv("TEST_TIP_TIERS", tc.tiers)
+
+			policy := tipPolicyFromEnv("TEST_TIP")
+			if policy.MinAmount != tc.wantMin {
+				t.Errorf("MinAmount = %d, want %d", policy.MinAmount, tc.wantMin)
+			}
+			if len(policy.Tiers) != len(tc.copies) {
+				t.Fatalf("%d tiers, want %d", len(policy.Tiers), len(tc.copies))
+			}
+			for i, tier := range policy.Tiers {
+				if i > 0 && tier.MinAmount <= policy.Tiers[i-1].MinAmount {
+					t.Errorf("tier %d (%d) isn't above tier %d (%d)", i, tier.MinAmount, i-1, policy.Tiers[i-1].MinAmount)
+				}
+				r := &Receipt{Notification: &template.StreamerNotification{}}
+				if err := tier.Apply(r, TemplateData{}); err != nil {
+					t.Fatalf("Apply: %v", err)
+				}
+				if r.copies() != tc.copies[i] {
+					t.Errorf("tier %d prints %d copies, want %d", tier.MinAmount, r.copies(), tc.copies[i])
+				}
+			}
+		})
+	}
+}
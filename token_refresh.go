@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"time"
+)
+
+const (
+	// tokenRefreshLead is how long before expiry the access token is refreshed
+	tokenRefreshLead = 5 * time.Minute
+
+	// tokenRetryInitialDelay and tokenRetryMaxDelay bound the backoff between
+	// retries of a failed refresh
+	tokenRetryInitialDelay = 5 * time.Second
+	tokenRetryMaxDelay     = 5 * time.Minute
+)
+
+// TokenRefreshStatus describes the outcome of automatic token refreshes
+type TokenRefreshStatus struct {
+	LastRefreshAt time.Time
+	NextRefreshAt time.Time
+	LastError     string
+	Failed        bool // a permanent failure that requires re-authentication
+}
+
+// StartTokenRefresher refreshes the access token ahead of its expiry until the
+// context is cancelled
+func (s *Server) StartTokenRefresher(ctx context.Context) {
+	go s.runTokenRefresher(ctx)
+}
+
+// notifyCredentialsChanged wakes the token refresher after new credentials were obtained
+func (s *Server) notifyCredentialsChanged() {
+	select {
+	case s.credsChanged <- struct{}{}:
+	default:
+	}
+}
+
+// TokenRefreshStatus returns a snapshot of the automatic token refresh state
+func (s *Server) TokenRefreshStatus() TokenRefreshStatus {
+	s.refreshMutex.RLock()
+	defer s.refreshMutex.RUnlock()
+	return s.refreshStatus
+}
+
+// runTokenRefresher waits for the refresh time of the current token, refreshes it
+// and repeats. Permanent failures stop refreshing until new credentials arrive.
+func (s *Server) runTokenRefresher(ctx context.Context) {
+	for {
+		s.credMutex.RLock()
+		refreshToken := s.credentials.RefreshToken
+		expiresAt := s.credentials.ExpiresAt
+		s.credMutex.RUnlock()
+
+		s.refreshMutex.RLock()
+		failed := s.refreshStatus.Failed
+		lastRefreshAt := s.refreshStatus.LastRefreshAt
+		s.refreshMutex.RUnlock()
+
+		// Nothing to refresh yet, wait for the next login
+		if refreshToken == "" || failed {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.credsChanged:
+				s.setRefreshStatus(func(st *TokenRefreshStatus) {
+					st.Failed = false
+					st.LastError = ""
+				})
+				continue
+			}
+		}
+
+		refreshAt := nextRefreshAt(expiresAt, lastRefreshAt)
+		s.setRefreshStatus(func(st *TokenRefreshStatus) {
+			st.NextRefreshAt = refreshAt
+		})
+
+		timer := time.NewTimer(time.Until(refreshAt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.credsChanged:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+
+		if err := s.refreshWithRetry(ctx, refreshToken); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("❌ Access token refresh failed permanently, re-authentication required: %v", err)
+			s.setRefreshStatus(func(st *TokenRefreshStatus) {
+				st.Failed = true
+				st.LastError = err.Error()
+				st.NextRefreshAt = time.Time{}
+			})
+		}
+	}
+}
+
+// nextRefreshAt returns when a token expiring at expiresAt is refreshed:
+// tokenRefreshLead before it expires, but no sooner than half its lifetime after
+// the last refresh. Tokens issued without expires_in, or living less than
+// tokenRefreshLead, would otherwise be refreshed again right away.
+func nextRefreshAt(expiresAt, lastRefreshAt time.Time) time.Time {
+	refreshAt := expiresAt.Add(-tokenRefreshLead)
+	if lastRefreshAt.IsZero() {
+		return refreshAt
+	}
+
+	wait := expiresAt.Sub(lastRefreshAt) / 2
+	if wait < tokenRetryInitialDelay {
+		wait = tokenRetryInitialDelay
+	}
+	if earliest := lastRefreshAt.Add(wait); refreshAt.Before(earliest) {
+		return earliest
+	}
+	return refreshAt
+}
+
+// refreshWithRetry refreshes the access token, retrying transient failures with
+// exponential backoff. It returns an error only for permanent failures.
+func (s *Server) refreshWithRetry(ctx context.Context, refreshToken string) error {
+	delay := tokenRetryInitialDelay
+	for attempt := 1; ; attempt++ {
+		err := s.RefreshAccessToken(ctx, refreshToken)
+		if err == nil {
+			return nil
+		}
+
+		var tokenErr *TokenError
+		if errors.As(err, &tokenErr) && !tokenErr.Temporary() {
+			return err
+		}
+
+		log.Printf("⚠️  Access token refresh attempt %d failed, retrying in %s: %v", attempt, delay, err)
+		s.setRefreshStatus(func(st *TokenRefreshStatus) {
+			st.LastError = err.Error()
+			st.NextRefreshAt = time.Now().Add(delay)
+		})
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		delay *= 2
+		if delay > tokenRetryMaxDelay {
+			delay = tokenRetryMaxDelay
+		}
+	}
+}
+
+// RefreshAccessToken exchanges a refresh token for a new access token and persists it
+func (s *Server) RefreshAccessToken(ctx context.Context, refreshToken string) error {
+	data := url.Values{}
+	data.Set("grant_type", "refresh_token")
+	data.Set("refresh_token", refreshToken)
+
+	tokenResp, err := s.joystick.RequestToken(ctx, data)
+	if err != nil {
+		return err
+	}
+
+	s.credMutex.Lock()
+	s.credentials.AccessToken = tokenResp.AccessToken
+	if tokenResp.RefreshToken != "" {
+		s.credentials.RefreshToken = tokenResp.RefreshToken
+	}
+	s.credentials.ExpiresAt = time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
+	expiresAt := s.credentials.ExpiresAt
+	s.credMutex.Unlock()
+
+	s.setRefreshStatus(func(st *TokenRefreshStatus) {
+		st.LastRefreshAt = time.Now()
+		st.LastError = ""
+		st.Failed = false
+	})
+
+	log.Printf("✓ Access token refreshed, expires at: %s", expiresAt.Format(time.RFC3339))
+
+	if err := s.SaveCredentials(); err != nil {
+		log.Printf("⚠️  Refreshed credentials could not be persisted: %v", err)
+	}
+	return nil
+}
+
+// setRefreshStatus applies an update to the token refresh status
+func (s *Server) setRefreshStatus(update func(*TokenRefreshStatus)) {
+	s.refreshMutex.Lock()
+	defer s.refreshMutex.Unlock()
+	update(&s.refreshStatus)
+}
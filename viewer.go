@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// subscriptionPeriod is how long a subscription lasts without a resubscription.
+// Subscriptions renew monthly, so a day is added for the longest month.
+const subscriptionPeriod = 31 * 24 * time.Hour
+
+// errViewerNotFound is returned when no viewer has the requested slug
+var errViewerNotFound = errors.New("viewer not found")
+
+// Viewer is a profile of one viewer aggregated from every event they caused
+type Viewer struct {
+	Slug        string  // viewerKey of the author slug, or the username events name them by
+	DisplayName *string // name as shown in chat, if known
+	FirstSeen   time.Time
+	LastSeen    time.Time
+	TipCount    int
+	TipTokens   int
+	FollowedAt  *time.Time // first follow
+	// SubscribedAt is the last subscription or resubscription
+	SubscribedAt       *time.Time
+	SubscriptionMonths int // months of the last resubscription
+	ChatMessageCount   int
+}
+
+// Name returns the display name, falling back to the slug
+func (v *Viewer) Name() string {
+	if v.DisplayName != nil && *v.DisplayName != "" {
+		return *v.DisplayName
+	}
+	return v.Slug
+}
+
+// SubscribedUntil returns when the last subscription runs out, or nil if the
+// viewer never subscribed
+func (v *Viewer) SubscribedUntil() *time.Time {
+	if v.SubscribedAt == nil {
+		return nil
+	}
+	until := v.SubscribedAt.Add(subscriptionPeriod)
+	return &until
+}
+
+// Subscribed reports whether the last subscription is still current
+func (v *Viewer) Subscribed() bool {
+	until := v.SubscribedUntil()
+	return until != nil && time.Now().Before(*until)
+}
+
+// ViewerStore maintains the viewers table
+type ViewerStore struct {
+	db *sql.DB
+}
+
+// NewViewerStore creates a new viewer store with a database connection
+func NewViewerStore(db *sql.DB) *ViewerStore {
+	return &ViewerStore{
+		db: db,
+	}
+}
+
+// viewerKey returns the viewers key of a user. Chat messages and stream events
+// name users by their slug, presence events by their username, so the key is
+// lowercased. Only ASCII letters are folded, like SQLite's lower() and NOCASE,
+// so keys made here and in SQL agree.
+func viewerKey(user string) string {
+	return strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, user)
+}
+
+// viewerColumns are the columns read into a Viewer, in scan order
+const viewerColumns = `slug, display_name, first_seen_timestamp, last_seen_timestamp, tip_count, tip_tokens,
+	followed_timestamp, subscribed_timestamp, subscription_months, chat_message_count`
+
+// RecordEvent adds an event to the profile of the viewer who caused it,
+// creating the profile on their first event. Events without a user are
+// ignored. Record each event once: the counters are not idempotent, so
+// redelivered events must be filtered out before.
+func (vs *ViewerStore) RecordEvent(ev *Event) error {
+	slug := viewerKey(ev.User())
+	if slug == "" {
+		return nil
+	}
+
+	var displayName *string
+	if name := ev.Author().DisplayName(); name != "" {
+		displayName = &name
+	}
+
+	seen := ev.ReceivedAt.Unix()
+	var tipCount, tipTokens, months, chatCount int
+	var followed, subscribed *int64
+	switch {
+	case ev.Tipped != nil:
+		tipCount = 1
+		tipTokens = ev.Tipped.HowMuch
+	case ev.Followed != nil:
+		followed = &seen
+	case ev.Subscribed != nil:
+		subscribed = &seen
+		months = ev.Subscribed.Months
+	case ev.Kind == KindChatMessage:
+		chatCount = 1
+	}
+
+	_, err := vs.db.Exec(`
+		INSERT INTO viewers (slug, display_name, first_seen_timestamp, last_seen_timestamp, tip_count, tip_tokens,
+			followed_timestamp, subscribed_timestamp, subscription_months, chat_message_count)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (slug) DO UPDATE SET
+			display_name = COALESCE(excluded.display_name, display_name),
+			first_seen_timestamp = MIN(first_seen_timestamp, excluded.first_seen_timestamp),
+			last_seen_timestamp = MAX(last_seen_timestamp, excluded.last_seen_timestamp),
+			tip_count = tip_count + excluded.tip_count,
+			tip_tokens = tip_tokens + excluded.tip_tokens,
+			followed_timestamp = COALESCE(followed_timestamp, excluded.followed_timestamp),
+			subscribed_timestamp = CASE WHEN excluded.subscribed_timestamp >= COALESCE(subscribed_timestamp, 0)
+				THEN excluded.subscribed_timestamp ELSE subscribed_timestamp END,
+			subscription_months = CASE WHEN excluded.subscribed_timestamp >= COALESCE(subscribed_timestamp, 0)
+				THEN excluded.subscription_months ELSE subscription_months END,
+			chat_message_count = chat_message_count + excluded.chat_message_count
+	`,
+		slug,
+		displayName,
+		seen,
+		seen,
+		tipCount,
+		tipTokens,
+		followed,
+		subscribed,
+		months,
+		chatCount,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record viewer %s: %w", slug, err)
+	}
+	return nil
+}
+
+// GetViewer retrieves the profile of a viewer by slug or username, in any case.
+// Returns errViewerNotFound if the viewer has no events.
+func (vs *ViewerStore) GetViewer(slug string) (*Viewer, error) {
+	rows, err := vs.db.Query(`
+		SELECT `+viewerColumns+`
+		FROM viewers
+		WHERE slug = ?
+	`, viewerKey(slug))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query viewer: %w", err)
+	}
+	viewers, err := scanViewers(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(viewers) == 0 {
+		return nil, errViewerNotFound
+	}
+	return &viewers[0], nil
+}
+
+// GetRecentViewers retrieves the most recently seen viewers
+func (vs *ViewerStore) GetRecentViewers(limit int) ([]Viewer, error) {
+	rows, err := vs.db.Query(`
+		SELECT `+viewerColumns+`
+		FROM viewers
+		ORDER BY last_seen_timestamp DESC, slug
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query viewers: %w", err)
+	}
+	return scanViewers(rows)
+}
+
+// GetTopTippers retrieves the viewers who tipped the most tokens
+func (vs *ViewerStore) GetTopTippers(limit int) ([]Viewer, error) {
+	rows, err := vs.db.Query(`
+		SELECT `+viewerColumns+`
+		FROM viewers
+		WHERE tip_tokens > 0
+		ORDER BY tip_tokens DESC, slug
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query viewers: %w", err)
+	}
+	return scanViewers(rows)
+}
+
+// scanViewers reads viewer rows and closes them
+func scanViewers(rows *sql.Rows) ([]Viewer, error) {
+	defer rows.Close()
+
+	var viewers []Viewer
+	for rows.Next() {
+		var v Viewer
+		var firstSeen, lastSeen int64
+		var followed, subscribed sql.NullInt64
+
+		err := rows.Scan(
+			&v.Slug,
+			&v.DisplayName,
+			&firstSeen,
+			&lastSeen,
+			&v.TipCount,
+			&v.TipTokens,
+			&followed,
+			&subscribed,
+			&v.SubscriptionMonths,
+			&v.ChatMessageCount,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan viewer: %w", err)
+		}
+
+		v.FirstSeen = time.Unix(firstSeen, 0)
+		v.LastSeen = time.Unix(lastSeen, 0)
+		if followed.Valid {
+			t := time.Unix(followed.Int64, 0)
+			v.FollowedAt = &t
+		}
+		if subscribed.Valid {
+			t := time.Unix(subscribed.Int64, 0)
+			v.SubscribedAt = &t
+		}
+		viewers = append(viewers, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating viewers: %w", err)
+	}
+
+	return viewers, nil
+}
+
+// viewerHistoryLimit is how many stream events and chat messages the viewer page lists
+const viewerHistoryLimit = 50
+
+// viewerPage is what the viewer page shows
+type viewerPage struct {
+	Viewer        *Viewer
+	Events        []dashboardEvent
+	Messages      []ChatMessageRecord
+	MessagesError string
+}
+
+// HandleViewer serves the profile of a viewer with their event history
+func (s *Server) HandleViewer(w http.ResponseWriter, r *http.Request) {
+	if s.viewerStore == nil || s.eventStore == nil {
+		http.Error(w, "Viewer store is not initialized", http.StatusServiceUnavailable)
+		return
+	}
+
+	slug := r.PathValue("slug")
+	viewer, err := s.viewerStore.GetViewer(slug)
+	if errors.Is(err, errViewerNotFound) {
+		http.Error(w, "Viewer not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("⚠️  Failed to load viewer %s: %v", slug, err)
+		http.Error(w, "Failed to load viewer", http.StatusInternalServerError)
+		return
+	}
+
+	events, err := s.eventStore.GetEventsByUser(viewer.Slug, viewerHistoryLimit)
+	if err != nil {
+		log.Printf("⚠️  Failed to load events of viewer %s: %v", slug, err)
+		http.Error(w, "Failed to load viewer", http.StatusInternalServerError)
+		return
+	}
+
+	var messages []ChatMessageRecord
+	var messagesError string
+	if s.chatStore != nil {
+		messages, err = s.chatStore.GetMessagesByUser(viewer.Slug, viewerHistoryLimit)
+		if err != nil {
+			log.Printf("⚠️  Failed to load chat messages of viewer %s: %v", slug, err)
+			messagesError = err.Error()
+		}
+	}
+
+	page := viewerPage{Viewer: viewer, MessagesError: messagesError}
+	for i := range events {
+		page.Events = append(page.Events, dashboardEvent{
+			StreamEvent: events[i],
+			Summary:     describeStoredEvent(&events[i]),
+		})
+	}
+	page.Messages = messages
+
+	var buf bytes.Buffer
+	if err := dashboardTemplates.ExecuteTemplate(&buf, "viewer.html", page); err != nil {
+		log.Printf("❌ Failed to render viewer page: %v", err)
+		http.Error(w, "Failed to render viewer page", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(buf.Bytes())
+}
+
+// describeStoredEvent summarizes a stored stream event for the viewer page
+func describeStoredEvent(se *StreamEvent) string {
+	frame, err := DecodeGatewayMessage([]byte(se.RawJSON))
+	if err != nil {
+		return ""
+	}
+	ev, _ := DecodeEvent(frame)
+	if ev == nil {
+		return ""
+	}
+	if ev.Tipped != nil {
+		summary := formatNumber(ev.Tipped.HowMuch) + " tokens"
+		if ev.Tipped.TipMenuItem != "" {
+			summary += " for " + ev.Tipped.TipMenuItem
+		}
+		return summary
+	}
+	return ev.Text()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tyrm/joysticktv-receipt-bot/fakejoystick"
+)
+
+// newTestViewerStore returns a viewer store on a fresh database
+func newTestViewerStore(t *testing.T) *ViewerStore {
+	t.Helper()
+
+	appDB, err := NewAppDatabase(t.TempDir() + "/app.db")
+	if err != nil {
+		t.Fatalf("NewAppDatabase: %v", err)
+	}
+	t.Cleanup(func() { appDB.Close() })
+	return NewViewerStore(appDB.GetDB())
+}
+
+// subscribedFrame returns a subscription frame, a resubscription if months is set
+func subscribedFrame(who string, months int) fakejoystick.Frame {
+	metadata := map[string]interface{}{"who": who, "what": "Subscribed"}
+	if months > 0 {
+		metadata["months"] = months
+	}
+	return fakejoystick.StreamEvent("subscribed", who+" subscribed", metadata, nil)
+}
+
+func TestViewerDisplayName(t *testing.T) {
+	vs := newTestViewerStore(t)
+
+	for _, author := range []fakejoystick.Author{
+		{Slug: "alice", Username: "alice"},
+		{Slug: "alice", Username: "alice", DisplayNameWithFlair: "Alice ✨"},
+	} {
+		if err := vs.RecordEvent(decodeFrame(t, fakejoystick.ChatMessage(author, "hi"))); err != nil {
+			t.Fatalf("RecordEvent: %v", err)
+		}
+	}
+	// Events without an author keep the name chat showed
+	if err := vs.RecordEvent(decodeFrame(t, fakejoystick.Followed("alice"))); err != nil {
+		t.Fatalf("RecordEvent: %v", err)
+	}
+
+	v, err := vs.GetViewer("alice")
+	if err != nil {
+		t.Fatalf("GetViewer: %v", err)
+	}
+	if v.Name() != "Alice ✨" || v.ChatMessageCount != 2 {
+		t.Errorf("viewer = %q with %d chat messages, want %q with 2", v.Name(), v.ChatMessageCount, "Alice ✨")
+	}
+}
+
+func TestViewerLastSubscription(t *testing.T) {
+	vs := newTestViewerStore(t)
+
+	now := time.Now().Truncate(time.Second)
+	for _, sub := range []struct {
+		months int
+		at     time.Time
+	}{
+		{6, now.Add(-90 * 24 * time.Hour)},
+		{0, now.Add(-10 * 24 * time.Hour)},
+		// Recorded late, so it isn't the last subscription
+		{7, now.Add(-60 * 24 * time.Hour)},
+	} {
+		ev := decodeFrame(t, subscribedFrame("carol", sub.months))
+		ev.ReceivedAt = sub.at
+		if err := vs.RecordEvent(ev); err != nil {
+			t.Fatalf("RecordEvent: %v", err)
+		}
+	}
+
+	v, err := vs.GetViewer("carol")
+	if err != nil {
+		t.Fatalf("GetViewer: %v", err)
+	}
+	if v.SubscribedAt == nil || !v.SubscribedAt.Equal(now.Add(-10*24*time.Hour)) || v.SubscriptionMonths != 0 {
+		t.Errorf("last subscription = %v for %d months, want 10 days ago for 0 months", v.SubscribedAt, v.SubscriptionMonths)
+	}
+	if !v.Subscribed() {
+		t.Error("a subscription from 10 days ago isn't current")
+	}
+}
+
+func TestViewerSubscribed(t *testing.T) {
+	ago := func(d time.Duration) *time.Time {
+		t := time.Now().Add(-d)
+		return &t
+	}
+
+	for _, tc := range []struct {
+		name         string
+		subscribedAt *time.Time
+		want         bool
+	}{
+		{"never", nil, false},
+		{"today", ago(time.Hour), true},
+		{"30 days ago", ago(30 * 24 * time.Hour), true},
+		{"lapsed", ago(32 * 24 * time.Hour), false},
+	} {
+		v := &Viewer{SubscribedAt: tc.subscribedAt}
+		if got := v.Subscribed(); got != tc.want {
+			t.Errorf("%s: Subscribed = %t, want %t", tc.name, got, tc.want)
+		}
+	}
+}